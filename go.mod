@@ -2,6 +2,7 @@ module github.com/zaydek/go-ipc-test
 
 go 1.17
 
-require github.com/evanw/esbuild v0.13.2
-
-require golang.org/x/sys v0.0.0-20210908233432-aa78b53d3365 // indirect
+require (
+	github.com/evanw/esbuild v0.13.2
+	golang.org/x/sys v0.0.0-20210908233432-aa78b53d3365
+)
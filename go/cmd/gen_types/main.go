@@ -0,0 +1,30 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/zaydek/go-ipc-test/go/cmd/retro"
+)
+
+// Writes the TypeScript protocol types, e.g.
+//
+//	go run ./go/cmd/gen_types -o node/scripts/backend/types.ts
+func main() {
+	out := flag.String("o", "", "output path")
+	flag.Parse()
+	if *out == "" {
+		fmt.Fprintln(os.Stderr, "Missing -o output path.")
+		os.Exit(1)
+	}
+
+	var buf bytes.Buffer
+	if err := retro.GenerateTypeScript(&buf); err != nil {
+		panic(fmt.Errorf("retro.GenerateTypeScript: %w", err))
+	}
+	if err := os.WriteFile(*out, buf.Bytes(), 0644); err != nil {
+		panic(fmt.Errorf("os.WriteFile: %w", err))
+	}
+}
@@ -0,0 +1,170 @@
+package retro
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/zaydek/go-ipc-test/go/pkg/ipc"
+)
+
+// How many backend output lines are queued per attached tool before lines are
+// dropped, so slow tools never block the backend
+const attachedOutputBuffer = 256
+
+var errNotDev = errors.New("retro: actions can only be sent in dev mode")
+
+// An external tool, e.g. an editor extension, attached to the host's socket
+type attachedTool struct {
+	client *ipc.Client
+	output chan string
+}
+
+// An action an attached tool asked the dev loop to send
+type actionRequest struct {
+	action string
+	result chan<- actionResult
+}
+
+type actionResult struct {
+	message interface{}
+	err     error
+}
+
+// Queues actions from attached tools while the dev loop runs
+type actionQueue struct {
+	requests chan actionRequest
+	done     chan struct{}
+}
+
+// Serves external tools attached to listener. Tools can call the methods
+// plugins can call and:
+//
+//	// Calls a JSON-RPC method on the current backend
+//	backend.call {"method": "ping", "params": null}
+//
+//	// Sends "rebuild" or "reload" in dev mode and returns the response
+//	backend.action "rebuild"
+//
+// Tools are sent the backend's stdout lines, i.e. messages and logs, as
+// "backend.output" notifications.
+func (r *RetroApp) serveAttached(listener *ipc.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		tool := &attachedTool{
+			client: ipc.NewClient(conn.Stdin(), conn.Stdout()),
+			output: make(chan string, attachedOutputBuffer),
+		}
+		r.registerHandlers(tool.client)
+		r.registerAttachedHandlers(tool.client)
+
+		r.mu.Lock()
+		if r.tools == nil {
+			r.tools = map[*attachedTool]bool{}
+		}
+		r.tools[tool] = true
+		r.mu.Unlock()
+
+		go func() {
+			for line := range tool.output {
+				tool.client.Notify("backend.output", line)
+			}
+		}()
+		go func() {
+			for line := range tool.client.Stdout() {
+				fmt.Println(decorateStdoutLine(line))
+			}
+			r.mu.Lock()
+			delete(r.tools, tool)
+			close(tool.output)
+			r.mu.Unlock()
+		}()
+	}
+}
+
+// Sends a backend stdout line to attached tools. JSON-RPC messages are
+// addressed to the host so they aren't sent.
+func (r *RetroApp) broadcast(line string) {
+	if ipc.IsMessage([]byte(line)) {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for tool := range r.tools {
+		select {
+		case tool.output <- line:
+		default:
+			// Dropped; the tool isn't keeping up
+		}
+	}
+}
+
+func (r *RetroApp) registerAttachedHandlers(client *ipc.Client) {
+	client.Handle("backend.call", func(params json.RawMessage) (interface{}, error) {
+		var call struct {
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+		}
+		if err := json.Unmarshal(params, &call); err != nil || call.Method == "" {
+			return nil, &ipc.Error{Code: ipc.InvalidParams, Message: "expected a method and params"}
+		}
+		r.mu.Lock()
+		backend := r.client
+		r.mu.Unlock()
+		if backend == nil {
+			return nil, &ipc.Error{Code: ipc.InternalError, Message: "the backend doesn't support JSON-RPC"}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), heartbeatTimeout)
+		defer cancel()
+		var callParams interface{}
+		if call.Params != nil {
+			callParams = call.Params
+		}
+		var result json.RawMessage
+		if err := backend.CallContext(ctx, call.Method, callParams, &result); err != nil {
+			return nil, err
+		}
+		return result, nil
+	})
+
+	client.Handle("backend.action", func(params json.RawMessage) (interface{}, error) {
+		var action string
+		if err := json.Unmarshal(params, &action); err != nil || (action != "rebuild" && action != "reload") {
+			return nil, &ipc.Error{Code: ipc.InvalidParams, Message: `expected "rebuild" or "reload"`}
+		}
+		r.mu.Lock()
+		queue := r.actions
+		r.mu.Unlock()
+		if queue == nil {
+			return nil, errNotDev
+		}
+
+		result := make(chan actionResult, 1)
+		select {
+		case queue.requests <- actionRequest{action: action, result: result}:
+		case <-queue.done:
+			return nil, errNotDev
+		}
+		sent := <-result
+		return sent.message, sent.err
+	})
+}
+
+// Accepts actions from attached tools until the returned function is called
+func (r *RetroApp) openActionQueue() (*actionQueue, func()) {
+	queue := &actionQueue{requests: make(chan actionRequest), done: make(chan struct{})}
+	r.mu.Lock()
+	r.actions = queue
+	r.mu.Unlock()
+	return queue, func() {
+		r.mu.Lock()
+		r.actions = nil
+		r.mu.Unlock()
+		close(queue.done)
+	}
+}
@@ -0,0 +1,101 @@
+package retro
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/zaydek/go-ipc-test/go/pkg/expect"
+	"github.com/zaydek/go-ipc-test/go/pkg/ipc"
+	"github.com/zaydek/go-ipc-test/go/pkg/ipc/ipctest"
+)
+
+func TestServeAttached(t *testing.T) {
+	process := ipctest.NewProcess(func(p *ipctest.Process, line string) {
+		if line == "rebuild" {
+			p.WriteStdout("rebuilding", `{"Kind":"rebuild_done","Data":{}}`)
+			return
+		}
+		// Echo JSON-RPC calls from the host
+		messages, err := ipc.DecodeMessages([]byte(line))
+		if err != nil || len(messages.Requests) == 0 {
+			return
+		}
+		request := messages.Requests[0]
+		p.WriteStdout(fmt.Sprintf(`{"jsonrpc":"2.0","id":%s,"result":%s}`, request.ID, request.Params))
+	}, helloLine(CapabilityJSONRPC))
+
+	r, s := startTestBackend(t, process)
+
+	listener, err := ipc.Listen()
+	if err != nil {
+		t.Fatalf("ipc.Listen: %s", err)
+	}
+	defer listener.Close()
+	go r.serveAttached(listener)
+
+	tool, err := net.Dial("unix", listener.Path())
+	if err != nil {
+		t.Fatalf("net.Dial: %s", err)
+	}
+	defer tool.Close()
+	reader := bufio.NewReader(tool)
+	call := func(line string) string {
+		fmt.Fprintln(tool, line)
+		response, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reader.ReadString: %s", err)
+		}
+		return response[:len(response)-1]
+	}
+	fmt.Fprintln(tool, "editor")
+
+	// Calls are forwarded to the backend
+	expect.DeepEqual(t, call(`{"jsonrpc":"2.0","id":1,"method":"backend.call","params":{"method":"echo","params":"foo"}}`),
+		`{"jsonrpc":"2.0","id":1,"result":"foo"}`)
+
+	// Actions fail outside the dev loop
+	expect.DeepEqual(t, call(`{"jsonrpc":"2.0","id":2,"method":"backend.action","params":"rebuild"}`),
+		`{"jsonrpc":"2.0","id":2,"error":{"code":-32603,"message":"retro: actions can only be sent in dev mode"}}`)
+
+	// Actions are sent by the dev loop, and the backend's output is forwarded
+	changes := make(chan []string)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if _, err := r.devLoop(s, changes); err != nil {
+			t.Errorf("r.devLoop: %s", err)
+		}
+	}()
+	for {
+		r.mu.Lock()
+		ready := r.actions != nil
+		r.mu.Unlock()
+		if ready {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// Notifications and the response can arrive in any order
+	fmt.Fprintln(tool, `{"jsonrpc":"2.0","id":3,"method":"backend.action","params":"rebuild"}`)
+	var lines []string
+	for len(lines) < 3 {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reader.ReadString: %s", err)
+		}
+		lines = append(lines, line[:len(line)-1])
+	}
+	sort.Strings(lines)
+	expect.DeepEqual(t, lines, []string{
+		`{"jsonrpc":"2.0","id":3,"result":{"Kind":"rebuild_done","Data":{"Client":{"Metafile":null,"Warnings":null,"Errors":null,"Duration":0}}}}`,
+		`{"jsonrpc":"2.0","method":"backend.output","params":"rebuilding"}`,
+		`{"jsonrpc":"2.0","method":"backend.output","params":"{\"Kind\":\"rebuild_done\",\"Data\":{}}"}`,
+	})
+
+	close(changes)
+	<-done
+}
@@ -6,6 +6,9 @@ const (
 
 	// Permission bits for writing directories
 	permDir = 0755
+
+	// Cache directory for vendor bundles, keyed by hash
+	vendorCacheDir = "node_modules/.cache/retro"
 )
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -16,3 +19,13 @@ const (
 	ModeDev   CommandMode = "dev"
 	ModeBuild CommandMode = "build"
 )
+
+////////////////////////////////////////////////////////////////////////////////
+
+// How the backend exchanges messages with Go; see RETRO_TRANSPORT
+type Transport = string
+
+const (
+	TransportPipes  Transport = "pipes"  // stdin and stdout
+	TransportSocket Transport = "socket" // A Unix socket external tools can attach to
+)
@@ -0,0 +1,163 @@
+package retro
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/evanw/esbuild/pkg/api"
+	"github.com/zaydek/go-ipc-test/go/pkg/terminal"
+	"github.com/zaydek/go-ipc-test/go/pkg/watch"
+)
+
+// Polling interval for source and configuration changes
+const watchInterval = 100 * time.Millisecond
+
+// Logs bundle warnings and errors
+func logBundleResult(result BundleResult) {
+	if len(result.Warnings) > 0 {
+		fmt.Fprint(os.Stderr, formatMessages(api.WarningMessage, result.Warnings))
+	}
+	if len(result.Errors) > 0 {
+		fmt.Fprint(os.Stderr, formatMessages(api.ErrorMessage, result.Errors))
+	}
+}
+
+// Logs a response to "build", "rebuild", or "reload". Errors in
+// `retro.config.js` are logged but don't stop the dev session.
+func logDevMessage(message interface{}) error {
+	switch message := message.(type) {
+	case ConfigurationErrorMessage:
+		fmt.Fprint(os.Stderr, formatMessages(api.ErrorMessage, message.Data.Errors))
+	case BuildDoneMessage:
+		logBundleResult(message.Data.Vendor)
+		logBundleResult(message.Data.Client)
+		fmt.Println(terminal.Dim("Built vendor and client bundles"))
+	case RebuildDoneMessage:
+		logBundleResult(message.Data.Client)
+		fmt.Println(terminal.Dim("Rebuilt client bundle"))
+	default:
+		return fmt.Errorf("unexpected message %T", message)
+	}
+	return nil
+}
+
+// Returns whether configPath, i.e. `retro.config.js`, is one of the changed
+// paths
+func configurationChanged(changed []string, configPath string) bool {
+	for _, path := range changed {
+		if path == configPath {
+			return true
+		}
+	}
+	return false
+}
+
+// Logs a wedged backend and restarts it. The restarted backend rebuilds from
+// scratch because the killed backend's incremental state is lost.
+func (r *RetroApp) restartBackend(wedged *WedgedBackendError) (*supervisor, error) {
+	fmt.Fprintln(os.Stderr, decorateStderrText(wedged.Error()))
+	fmt.Println(terminal.Dim("Restarting backend"))
+
+	s, err := r.startBackend()
+	if err != nil {
+		return nil, fmt.Errorf("r.startBackend: %w", err)
+	}
+	message, err := r.buildBundles(s)
+	if err != nil {
+		s.kill()
+		return nil, fmt.Errorf("r.buildBundles: %w", err)
+	}
+	if err := logDevMessage(message); err != nil {
+		s.kill()
+		return nil, fmt.Errorf("logDevMessage: %w", err)
+	}
+	return s, nil
+}
+
+func (r *RetroApp) Dev() error {
+	if err := r.warmUp(ModeDev); err != nil {
+		return fmt.Errorf("warmUp: %w", err)
+	}
+
+	defer r.handleSignals()()
+	defer r.printMetrics()
+	if RETRO_METRICS_ADDR != "" {
+		stopServing, err := r.serveMetrics(RETRO_METRICS_ADDR)
+		if err != nil {
+			return fmt.Errorf("r.serveMetrics: %w", err)
+		}
+		defer stopServing()
+	}
+	defer r.stopListening()
+	s, err := r.startBackend()
+	if err != nil {
+		return fmt.Errorf("r.startBackend: %w", err)
+	}
+	defer func() { s.stop() }()
+
+	message, err := r.buildBundles(s)
+	if err != nil {
+		return fmt.Errorf("r.buildBundles: %w", err)
+	}
+	if err := logDevMessage(message); err != nil {
+		return fmt.Errorf("logDevMessage: %w", err)
+	}
+
+	changes, stop := watch.Watch(watchInterval, r.path(RETRO_SRC_DIR), r.path("retro.config.js"))
+	defer stop()
+
+	if s, err = r.devLoop(s, changes); err != nil {
+		return fmt.Errorf("r.devLoop: %w", err)
+	}
+	return nil
+}
+
+// Rebuilds on changes until changes is closed. Changes to `retro.config.js`
+// reload the configuration and rebuild from scratch; other changes rebuild the
+// client bundle incrementally. Actions from attached tools are sent in between.
+// Wedged backends are restarted. Returns the current supervisor, which is s
+// unless the backend was restarted.
+func (r *RetroApp) devLoop(s *supervisor, changes <-chan []string) (*supervisor, error) {
+	queue, closeQueue := r.openActionQueue()
+	defer closeQueue()
+
+	for {
+		var action string
+		var request *actionRequest
+		select {
+		case changed, ok := <-changes:
+			if !ok {
+				return s, nil
+			}
+			action = "rebuild"
+			if configurationChanged(changed, r.path("retro.config.js")) && r.Backend.Has(CapabilityReload) {
+				action = "reload"
+			}
+		case received := <-queue.requests:
+			request = &received
+			action = received.action
+		}
+		s.send(action)
+		message, err := s.await()
+		if request != nil {
+			request.result <- actionResult{message: message, err: err}
+		}
+		if err != nil {
+			var wedged *WedgedBackendError
+			if !errors.As(err, &wedged) {
+				return s, fmt.Errorf("s.await: %w", err)
+			}
+			restarted, err := r.restartBackend(wedged)
+			if err != nil {
+				return s, fmt.Errorf("r.restartBackend: %w", err)
+			}
+			s = restarted
+			continue
+		}
+		if err := logDevMessage(message); err != nil {
+			return s, fmt.Errorf("logDevMessage: %w", err)
+		}
+	}
+}
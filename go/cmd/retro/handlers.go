@@ -0,0 +1,62 @@
+package retro
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/zaydek/go-ipc-test/go/pkg/ipc"
+)
+
+// Describes the environment exposed to plugins
+type HostEnv struct {
+	NODE_ENV      string
+	RETRO_CMD     string
+	RETRO_WWW_DIR string
+	RETRO_SRC_DIR string
+	RETRO_OUT_DIR string
+	Public        map[string]string // `RETRO_PUBLIC_` variables
+}
+
+// Methods plugins in `retro.config.js` can call, e.g.
+//
+//	const env = await retro.call("env")
+//	const hash = await retro.call("hashFile", "src/App.js")
+func (r *RetroApp) registerHandlers(client *ipc.Client) {
+	client.Handle("env", func(json.RawMessage) (interface{}, error) {
+		env := HostEnv{
+			NODE_ENV:      NODE_ENV,
+			RETRO_CMD:     RETRO_CMD,
+			RETRO_WWW_DIR: RETRO_WWW_DIR,
+			RETRO_SRC_DIR: RETRO_SRC_DIR,
+			RETRO_OUT_DIR: RETRO_OUT_DIR,
+			Public:        map[string]string{},
+		}
+		for _, keyValue := range append(os.Environ(), r.env...) {
+			if key := strings.SplitN(keyValue, "=", 2)[0]; strings.HasPrefix(key, "RETRO_PUBLIC_") {
+				env.Public[key] = r.getenv(key)
+			}
+		}
+		return env, nil
+	})
+
+	client.Handle("hashFile", func(params json.RawMessage) (interface{}, error) {
+		var path string
+		if err := json.Unmarshal(params, &path); err != nil {
+			return nil, &ipc.Error{Code: ipc.InvalidParams, Message: "expected a path"}
+		}
+		if !filepath.IsAbs(path) {
+			path = r.path(path)
+		}
+		byteStr, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("os.ReadFile: %w", err)
+		}
+		hash := sha256.Sum256(byteStr)
+		return hex.EncodeToString(hash[:]), nil
+	})
+}
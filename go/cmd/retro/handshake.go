@@ -0,0 +1,80 @@
+package retro
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// How long to wait for the backend's hello message. Backends that predate the
+// handshake never send one.
+const handshakeTimeout = 10 * time.Second
+
+// Describes the backend after the handshake
+type Backend struct {
+	ProtocolVersion int
+	EsbuildVersion  string
+	NodeVersion     string
+
+	// Capabilities both the host and the backend support
+	Capabilities []string
+}
+
+func (b Backend) Has(capability string) bool {
+	for _, c := range b.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// Describes a backend that speaks a different protocol version, usually
+// because `backend.esbuild.js` is stale
+type IncompatibleBackendError struct {
+	Got  int // Zero when the backend never said hello
+	Want int
+}
+
+func (e *IncompatibleBackendError) Error() string {
+	if e.Got == 0 {
+		return fmt.Sprintf("backend.esbuild.js didn't send a hello message (want protocol version %d); "+
+			"run `make` to rebuild backend.esbuild.js", e.Want)
+	}
+	return fmt.Sprintf("backend.esbuild.js speaks protocol version %d but retro speaks protocol version %d; "+
+		"run `make` to rebuild backend.esbuild.js", e.Got, e.Want)
+}
+
+// Waits for the backend's hello message, verifies the protocol version, and
+// negotiates capabilities
+func handshake(stdout, stderr <-chan string) (Backend, error) {
+	message, err := awaitMessageTimeout(stdout, stderr, time.After(handshakeTimeout))
+	if err != nil {
+		if errors.Is(err, errTimeout) {
+			return Backend{}, &IncompatibleBackendError{Want: ProtocolVersion}
+		}
+		return Backend{}, fmt.Errorf("awaitMessageTimeout: %w", err)
+	}
+	hello, ok := message.(HelloMessage)
+	if !ok {
+		return Backend{}, &IncompatibleBackendError{Want: ProtocolVersion}
+	}
+
+	if hello.Data.ProtocolVersion != ProtocolVersion {
+		return Backend{}, &IncompatibleBackendError{Got: hello.Data.ProtocolVersion, Want: ProtocolVersion}
+	}
+
+	backend := Backend{
+		ProtocolVersion: hello.Data.ProtocolVersion,
+		EsbuildVersion:  hello.Data.EsbuildVersion,
+		NodeVersion:     hello.Data.NodeVersion,
+	}
+	for _, capability := range hostCapabilities {
+		for _, backendCapability := range hello.Data.Capabilities {
+			if capability == backendCapability {
+				backend.Capabilities = append(backend.Capabilities, capability)
+			}
+		}
+	}
+	return backend, nil
+}
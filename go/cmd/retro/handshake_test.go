@@ -0,0 +1,48 @@
+package retro
+
+import (
+	"testing"
+
+	"github.com/zaydek/go-ipc-test/go/pkg/expect"
+)
+
+func TestHandshake(t *testing.T) {
+	stdout := make(chan string, 1)
+	stdout <- `{"Kind":"hello","Data":{"ProtocolVersion":1,"EsbuildVersion":"0.13.2","NodeVersion":"v16.10.0","Capabilities":["reload","jsonrpc","foo"]}}`
+
+	backend, err := handshake(stdout, nil)
+	if err != nil {
+		t.Fatalf("handshake: %s", err)
+	}
+	expect.DeepEqual(t, backend, Backend{
+		ProtocolVersion: 1,
+		EsbuildVersion:  "0.13.2",
+		NodeVersion:     "v16.10.0",
+		Capabilities:    []string{CapabilityReload, CapabilityJSONRPC},
+	})
+	expect.DeepEqual(t, backend.Has(CapabilityVendorCache), false)
+}
+
+func TestHandshakeIncompatible(t *testing.T) {
+	stdout := make(chan string, 1)
+	stdout <- `{"Kind":"hello","Data":{"ProtocolVersion":999}}`
+
+	_, err := handshake(stdout, nil)
+	incompatibleErr, ok := err.(*IncompatibleBackendError)
+	if !ok {
+		t.Fatalf("handshake: got %v want *IncompatibleBackendError", err)
+	}
+	expect.DeepEqual(t, *incompatibleErr, IncompatibleBackendError{Got: 999, Want: ProtocolVersion})
+}
+
+func TestHandshakeStale(t *testing.T) {
+	stdout := make(chan string, 1)
+	stdout <- `{"Kind":"build_done","Data":{}}`
+
+	_, err := handshake(stdout, nil)
+	incompatibleErr, ok := err.(*IncompatibleBackendError)
+	if !ok {
+		t.Fatalf("handshake: got %v want *IncompatibleBackendError", err)
+	}
+	expect.DeepEqual(t, incompatibleErr.Got, 0)
+}
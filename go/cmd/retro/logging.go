@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/evanw/esbuild/pkg/api"
 	"github.com/zaydek/go-ipc-test/go/pkg/terminal"
 )
 
@@ -56,3 +57,15 @@ func decorateStderrText(text string) string {
 	}
 	return stderr
 }
+
+// Formats warnings or errors the way esbuild does, e.g.
+//
+//	> retro.config.js:3:1: error: Unknown option `outDir`
+//	    3 │   outDir: "dist",
+//	      ╵   ~~~~~~
+func formatMessages(kind api.MessageKind, messages []api.Message) string {
+	return strings.Join(api.FormatMessages(messages, api.FormatMessagesOptions{
+		Color: true,
+		Kind:  kind,
+	}), "")
+}
@@ -0,0 +1,40 @@
+package retro
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/zaydek/go-ipc-test/go/pkg/terminal"
+)
+
+// Prints a summary of backend metrics when RETRO_METRICS is "true"
+func (r *RetroApp) printMetrics() {
+	if RETRO_METRICS != "true" || r.metrics == nil {
+		return
+	}
+	var str strings.Builder
+	if err := r.metrics.WriteSummary(&str); err != nil {
+		return
+	}
+	fmt.Print(terminal.Dim(str.String()))
+}
+
+// Serves backend metrics in the Prometheus text format at `/metrics` on addr
+// until stop is called
+func (r *RetroApp) serveMetrics(addr string) (stop func(), err error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("net.Listen: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.metrics.WritePrometheus(w)
+	})
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+	fmt.Println(terminal.Dim("Serving metrics on http://" + listener.Addr().String() + "/metrics"))
+	return func() { server.Close() }, nil
+}
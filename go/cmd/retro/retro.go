@@ -2,24 +2,101 @@ package retro
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
+	"time"
 
+	"github.com/evanw/esbuild/pkg/api"
 	"github.com/zaydek/go-ipc-test/go/pkg/ipc"
 )
 
-type RetroApp struct{}
+type RetroApp struct {
+	// Modules to bundle in `vendor.js`; takes precedence over `vendor` in
+	// `retro.config.js`
+	Vendor []string
 
-func warmUp(commandMode CommandMode) error {
-	setEnvsAndGlobalVariables(commandMode) // Takes precedence
+	// Describes the backend and its negotiated capabilities, set after the
+	// backend is started
+	Backend Backend
 
-	if err := os.RemoveAll(RETRO_OUT_DIR); err != nil {
+	// Starts the backend process; defaults to Node. Tests can start an
+	// ipctest.Process instead.
+	StartBackend func() (ipc.Transport, error)
+
+	// The project directory, which contains `retro.config.js`; defaults to the
+	// current directory
+	Dir string
+
+	// The backend's environment on top of the OS environment, including `.env`
+	// files and defaults; see setEnvsAndGlobalVariables
+	env []string
+
+	// Guards process, client, listener, tools, and actions, which are read
+	// when a signal is received or by attached tools
+	mu sync.Mutex
+
+	// The current backend, which is killed on SIGINT or SIGTERM
+	process ipc.Transport
+
+	// The current backend's JSON-RPC client, which attached tools call through;
+	// nil when the backend doesn't support JSON-RPC
+	client *ipc.Client
+
+	// Collects backend metrics across restarts; set by warmUp. See RETRO_METRICS.
+	metrics *ipc.Metrics
+
+	// Listens for the backend when RETRO_TRANSPORT is "socket"
+	listener *ipc.Listener
+
+	// Tools attached to listener, which are sent the backend's output
+	tools map[*attachedTool]bool
+
+	// Actions attached tools asked the dev loop to send; nil outside the dev
+	// loop
+	actions *actionQueue
+
+	// Compresses messages to and from the current backend when it's started
+	// by startNode
+	compressor *ipc.Compressor
+
+	// How many backend sessions were recorded to RETRO_RECORD
+	recordedSessions int
+}
+
+// Returned by Build when `retro.config.js` is invalid. The errors are logged
+// first.
+var ErrConfiguration = errors.New("invalid retro.config.js")
+
+var (
+	errBackendStopped = errors.New("backend stopped")
+	errTimeout        = errors.New("timed out")
+	errBundleFailed   = errors.New("bundle failed") // For metrics
+)
+
+// Resolves a path relative to the project directory. Absolute paths, e.g. from
+// RETRO_RECORD, are returned as is.
+func (r *RetroApp) path(elem ...string) string {
+	if len(elem) > 0 && filepath.IsAbs(elem[0]) {
+		return filepath.Join(elem...)
+	}
+	return filepath.Join(append([]string{r.Dir}, elem...)...)
+}
+
+func (r *RetroApp) warmUp(commandMode CommandMode) error {
+	if err := r.setEnvsAndGlobalVariables(commandMode); err != nil {
+		return fmt.Errorf("r.setEnvsAndGlobalVariables: %w", err)
+	}
+	r.metrics = ipc.NewMetrics()
+
+	if err := os.RemoveAll(r.path(RETRO_OUT_DIR)); err != nil {
 		return fmt.Errorf("os.RemoveAll: %w", err)
 	}
 
 	// Check for the presence of `www/index.html`
-	if _, err := os.Stat(filepath.Join(RETRO_WWW_DIR, "index.html")); err != nil {
+	if _, err := os.Stat(r.path(RETRO_WWW_DIR, "index.html")); err != nil {
 		return fmt.Errorf("os.Stat: %w", err)
 	} else if os.IsNotExist(err) {
 		fmt.Fprintln(
@@ -30,7 +107,7 @@ func warmUp(commandMode CommandMode) error {
 	}
 
 	// Check for the presence of `src/index.js`
-	if _, err := os.Stat(filepath.Join(RETRO_SRC_DIR, "index.js")); err != nil {
+	if _, err := os.Stat(r.path(RETRO_SRC_DIR, "index.js")); err != nil {
 		return fmt.Errorf("os.Stat: %w", err)
 	} else if os.IsNotExist(err) {
 		fmt.Fprintln(
@@ -41,7 +118,7 @@ func warmUp(commandMode CommandMode) error {
 	}
 
 	// Check for the presence of `src/App.js`
-	if _, err := os.Stat(filepath.Join(RETRO_SRC_DIR, "App.js")); err != nil {
+	if _, err := os.Stat(r.path(RETRO_SRC_DIR, "App.js")); err != nil {
 		return fmt.Errorf("os.Stat: %w", err)
 	} else if os.IsNotExist(err) {
 		fmt.Fprintln(
@@ -54,35 +131,118 @@ func warmUp(commandMode CommandMode) error {
 	return nil
 }
 
+// Waits for the next message from the backend and decodes it. Unencoded stdout
+// lines are logged so users can debug plugins, etc. stderr text means the
+// backend stopped and is logged and returned as an error. Returns errTimeout
+// when timeout fires first.
+func awaitMessageTimeout(stdout, stderr <-chan string, timeout <-chan time.Time) (interface{}, error) {
+	for {
+		select {
+		case <-timeout:
+			return nil, errTimeout
+		case line, ok := <-stdout:
+			if !ok {
+				return nil, errBackendStopped
+			}
+			if !isEnvelope([]byte(line)) {
+				fmt.Println(decorateStdoutLine(line))
+				continue
+			}
+			message, err := Decode([]byte(line))
+			if err != nil {
+				return nil, fmt.Errorf("Decode: %w", err)
+			}
+			return message, nil
+		case text, ok := <-stderr:
+			if !ok {
+				stderr = nil
+				continue
+			}
+			fmt.Println(decorateStderrText(text))
+			return nil, errBackendStopped
+		}
+	}
+}
+
+// Builds the vendor and client bundles, using the vendor cache when the
+// backend supports it. The cache is bypassed when recording or replaying so
+// replays send the recorded actions regardless of what's cached.
+func (r *RetroApp) buildBundles(s *supervisor) (interface{}, error) {
+	if r.Backend.Has(CapabilityVendorCache) && RETRO_RECORD == "" && RETRO_REPLAY == "" {
+		return buildWithVendorCache(s, r.Dir)
+	}
+	s.send("build")
+	return s.await()
+}
+
+// Starts the backend and shakes hands with it
+func (r *RetroApp) startBackend() (*supervisor, error) {
+	r.compressor = nil
+	startBackend := r.StartBackend
+	if startBackend == nil {
+		startBackend = r.startNode
+		if RETRO_REPLAY != "" {
+			startBackend = r.startReplay
+		}
+	}
+	process, err := startBackend()
+	if err != nil {
+		return nil, fmt.Errorf("startBackend: %w", err)
+	}
+	r.mu.Lock()
+	r.process = process
+	r.mu.Unlock()
+	if RETRO_RECORD != "" {
+		recorded, err := r.record(process)
+		if err != nil {
+			process.Kill()
+			return nil, fmt.Errorf("record: %w", err)
+		}
+		process = recorded
+	}
+	s, backend, err := r.newSupervisor(process)
+	if err != nil {
+		return nil, fmt.Errorf("r.newSupervisor: %w", err)
+	}
+	r.Backend = backend
+	r.mu.Lock()
+	r.client = nil
+	if backend.Has(CapabilityJSONRPC) {
+		r.client = s.client
+	}
+	r.mu.Unlock()
+	return s, nil
+}
+
 func (r *RetroApp) Build() error {
-	if err := warmUp(ModeBuild); err != nil {
+	if err := r.warmUp(ModeBuild); err != nil {
 		return fmt.Errorf("warmUp: %w", err)
 	}
 
-	stdin, stdout, stderr, err := ipc.NewCommand("node", "node/scripts/backend.esbuild.js")
+	defer r.handleSignals()()
+	defer r.printMetrics()
+	defer r.stopListening()
+	s, err := r.startBackend()
 	if err != nil {
-		return fmt.Errorf("ipc.NewCommand: %w", err)
+		return fmt.Errorf("r.startBackend: %w", err)
 	}
+	defer s.stop()
 
-	var message BuildDoneMessage
+	received, err := r.buildBundles(s)
+	if err != nil {
+		return fmt.Errorf("buildBundles: %w", err)
+	}
 
-	stdin <- "build"
-loop:
-	for {
-		select {
-		case line := <-stdout:
-			if err := json.Unmarshal([]byte(line), &message); err != nil {
-				// Log unmarshal errors as stdout so users can debug plugins, etc.
-				fmt.Println(decorateStdoutLine(line))
-				continue
-			}
-			stdin <- "done"
-			break loop
-		case text := <-stderr:
-			fmt.Println(decorateStderrText(text))
-			stdin <- "done"
-			break loop
-		}
+	var message BuildDoneMessage
+	switch received := received.(type) {
+	case ConfigurationErrorMessage:
+		fmt.Fprint(os.Stderr, formatMessages(api.ErrorMessage, received.Data.Errors))
+		// Return rather than exit so the backend is torn down
+		return ErrConfiguration
+	case BuildDoneMessage:
+		message = received
+	default:
+		return fmt.Errorf("unexpected message %T", received)
 	}
 
 	// DEBUG
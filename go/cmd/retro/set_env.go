@@ -3,6 +3,13 @@ package retro
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zaydek/go-ipc-test/go/pkg/dotenv"
 )
 
 var (
@@ -11,16 +18,65 @@ var (
 	RETRO_WWW_DIR = ""
 	RETRO_SRC_DIR = ""
 	RETRO_OUT_DIR = ""
+	RETRO_VENDOR  = ""
+	RETRO_RECORD  = ""
+	RETRO_REPLAY  = ""
+
+	RETRO_TRANSPORT      = ""
+	RETRO_PTY            = ""
+	RETRO_ACTION_TIMEOUT = ""
+	RETRO_MEMORY_LIMIT   = ""
+
+	RETRO_METRICS      = ""
+	RETRO_METRICS_ADDR = ""
 )
 
-// Propagates environmental variables or sets default values
-func setEnvsAndGlobalVariables(commandMode CommandMode) error {
-	var err error
-	setEnv := func(envKey, fallbackValue string) {
-		if err != nil {
-			return
+// Reads `.env` files in dir in ascending order of precedence: `.env`, then the
+// mode's committed file, e.g. `.env.production`, then the gitignored
+// `.env.local` and `.env.production.local`, so local files override committed
+// ones. Variables lookup resolves always take precedence.
+func readDotenvFiles(dir, nodeEnv string, lookup func(string) (string, bool)) (map[string]string, error) {
+	var mode string
+	switch nodeEnv {
+	case "development", "production":
+		mode = nodeEnv
+	}
+	filenames := []string{".env"}
+	if mode != "" {
+		filenames = append(filenames, ".env."+mode)
+	}
+	filenames = append(filenames, ".env.local")
+	if mode != "" {
+		filenames = append(filenames, ".env."+mode+".local")
+	}
+	for index, filename := range filenames {
+		filenames[index] = filepath.Join(dir, filename)
+	}
+	vars, err := dotenv.Read(lookup, filenames...)
+	if err != nil {
+		return nil, fmt.Errorf("dotenv.Read: %w", err)
+	}
+	return vars, nil
+}
+
+// Propagates environmental variables or sets default values. Variables are
+// passed to the backend rather than set in the environment so the project
+// directory can differ from the current directory.
+func (r *RetroApp) setEnvsAndGlobalVariables(commandMode CommandMode) error {
+	env := map[string]string{}
+	if len(r.Vendor) > 0 {
+		// Takes precedence
+		env["RETRO_VENDOR"] = strings.Join(r.Vendor, ",")
+	}
+	lookup := func(envKey string) (string, bool) {
+		if envValue, ok := env[envKey]; ok {
+			return envValue, true
 		}
-		envValue := os.Getenv(envKey)
+		return os.LookupEnv(envKey)
+	}
+
+	setEnv := func(envKey, fallbackValue string) {
+		envValue, _ := lookup(envKey)
 		if envValue == "" {
 			envValue = fallbackValue
 		}
@@ -35,10 +91,26 @@ func setEnvsAndGlobalVariables(commandMode CommandMode) error {
 			RETRO_SRC_DIR = envValue
 		case "RETRO_OUT_DIR":
 			RETRO_OUT_DIR = envValue
+		case "RETRO_VENDOR":
+			RETRO_VENDOR = envValue
+		case "RETRO_RECORD":
+			RETRO_RECORD = envValue
+		case "RETRO_REPLAY":
+			RETRO_REPLAY = envValue
+		case "RETRO_TRANSPORT":
+			RETRO_TRANSPORT = envValue
+		case "RETRO_PTY":
+			RETRO_PTY = envValue
+		case "RETRO_ACTION_TIMEOUT":
+			RETRO_ACTION_TIMEOUT = envValue
+		case "RETRO_MEMORY_LIMIT":
+			RETRO_MEMORY_LIMIT = envValue
+		case "RETRO_METRICS":
+			RETRO_METRICS = envValue
+		case "RETRO_METRICS_ADDR":
+			RETRO_METRICS_ADDR = envValue
 		}
-		if err = os.Setenv(envKey, envValue); err != nil {
-			err = fmt.Errorf("os.Setenv: %w", err)
-		}
+		env[envKey] = envValue
 	}
 	switch commandMode {
 	case ModeDev:
@@ -46,6 +118,13 @@ func setEnvsAndGlobalVariables(commandMode CommandMode) error {
 	case ModeBuild:
 		setEnv("NODE_ENV", "production")
 	}
+	dotenvVars, err := readDotenvFiles(r.Dir, NODE_ENV, lookup)
+	if err != nil {
+		return fmt.Errorf("readDotenvFiles: %w", err)
+	}
+	for envKey, envValue := range dotenvVars {
+		env[envKey] = envValue
+	}
 	switch commandMode {
 	case ModeDev:
 		setEnv("RETRO_CMD", ModeDev)
@@ -55,5 +134,59 @@ func setEnvsAndGlobalVariables(commandMode CommandMode) error {
 	setEnv("RETRO_WWW_DIR", "www")
 	setEnv("RETRO_SRC_DIR", "src")
 	setEnv("RETRO_OUT_DIR", "out")
-	return err
+	setEnv("RETRO_VENDOR", "") // Defers to `retro.config.js`
+	setEnv("RETRO_RECORD", "") // Path to record backend sessions to
+	setEnv("RETRO_REPLAY", "") // Path to replay a recorded session from
+	setEnv("RETRO_TRANSPORT", TransportPipes)
+	setEnv("RETRO_PTY", "false")                                  // Runs the backend under a pseudo-terminal on Linux
+	setEnv("RETRO_METRICS", "false")                              // Prints a summary of backend metrics on exit
+	setEnv("RETRO_METRICS_ADDR", "")                              // Serves Prometheus metrics in dev, e.g. "localhost:9100"
+	setEnv("RETRO_ACTION_TIMEOUT", defaultActionTimeout.String()) // e.g. "10m" for large builds or "0" to disable
+	if _, err := time.ParseDuration(RETRO_ACTION_TIMEOUT); err != nil {
+		return fmt.Errorf("RETRO_ACTION_TIMEOUT: %w", err)
+	}
+	setEnv("RETRO_MEMORY_LIMIT", "0") // Node's heap limit in MiB, e.g. "2048" in CI, or "0" for Node's default
+	if _, err := strconv.Atoi(RETRO_MEMORY_LIMIT); err != nil {
+		return fmt.Errorf("RETRO_MEMORY_LIMIT: %w", err)
+	}
+
+	r.env = nil
+	for envKey, envValue := range env {
+		r.env = append(r.env, envKey+"="+envValue)
+	}
+	sort.Strings(r.env)
+	return nil
+}
+
+// Returns how long an action can take per RETRO_ACTION_TIMEOUT, e.g. "10m" for
+// large production builds or "0" to rely on heartbeats alone
+func actionTimeout() time.Duration {
+	timeout, err := time.ParseDuration(RETRO_ACTION_TIMEOUT)
+	if err != nil {
+		// Validated by setEnvsAndGlobalVariables; unset in tests
+		return defaultActionTimeout
+	}
+	return timeout
+}
+
+// Returns the backend's heap limit in MiB per RETRO_MEMORY_LIMIT; zero means
+// Node's default
+func memoryLimit() int {
+	mib, err := strconv.Atoi(RETRO_MEMORY_LIMIT)
+	if err != nil {
+		// Validated by setEnvsAndGlobalVariables; unset in tests
+		return 0
+	}
+	return mib
+}
+
+// Returns the value of an environmental variable as the backend sees it
+func (r *RetroApp) getenv(envKey string) string {
+	envValue := os.Getenv(envKey)
+	for _, keyValue := range r.env {
+		if strings.HasPrefix(keyValue, envKey+"=") {
+			envValue = keyValue[len(envKey)+1:]
+		}
+	}
+	return envValue
 }
@@ -0,0 +1,41 @@
+package retro
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zaydek/go-ipc-test/go/pkg/expect"
+)
+
+func TestReadDotenvFiles(t *testing.T) {
+	dir := t.TempDir()
+	for filename, contents := range map[string]string{
+		".env":                   "A=env\nB=env\nC=env\nD=env\nE=env\n",
+		".env.development":       "B=development\nC=development\nD=development\n",
+		".env.local":             "C=local\nD=local\n",
+		".env.development.local": "D=development.local\n",
+		".env.production":        "E=production\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, filename), []byte(contents), permFile); err != nil {
+			t.Fatalf("os.WriteFile: %s", err)
+		}
+	}
+
+	lookup := func(envKey string) (string, bool) {
+		if envKey == "A" {
+			return "lookup", true
+		}
+		return "", false
+	}
+	vars, err := readDotenvFiles(dir, "development", lookup)
+	if err != nil {
+		t.Fatalf("readDotenvFiles: %s", err)
+	}
+	expect.DeepEqual(t, vars, map[string]string{
+		"B": "development",
+		"C": "local",
+		"D": "development.local",
+		"E": "env",
+	})
+}
@@ -0,0 +1,44 @@
+package retro
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Tears down the backend and its descendants on SIGINT or SIGTERM and exits.
+// The backend runs in its own process group so it no longer receives signals
+// sent to the terminal's foreground process group, e.g. Ctrl-C.
+func (r *RetroApp) handleSignals() (stop func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-signals:
+			r.shutdown()
+			code := 1
+			if sig, ok := sig.(syscall.Signal); ok {
+				code = 128 + int(sig) // The shell convention, e.g. 130 for SIGINT
+			}
+			os.Exit(code)
+		case <-done:
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+// Kills the current backend, if any, stops listening, and prints metrics
+func (r *RetroApp) shutdown() {
+	r.mu.Lock()
+	process := r.process
+	r.mu.Unlock()
+	if process != nil {
+		process.Kill()
+	}
+	r.stopListening()
+	r.printMetrics()
+}
@@ -0,0 +1,386 @@
+package retro
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zaydek/go-ipc-test/go/pkg/ipc"
+	"github.com/zaydek/go-ipc-test/go/pkg/terminal"
+)
+
+const (
+	// How long an action, e.g. "build", can take before the backend is
+	// considered wedged, unless RETRO_ACTION_TIMEOUT is set
+	defaultActionTimeout = 2 * time.Minute
+
+	// How often the backend is pinged and how long it has to respond. Builds are
+	// asynchronous so a healthy backend responds to pings mid-build.
+	heartbeatInterval = 2 * time.Second
+	heartbeatTimeout  = 10 * time.Second
+
+	// How many lines of backend output to keep for WedgedBackendError
+	outputTailLines = 20
+
+	// The maximum size of backend messages. Messages embed esbuild metafiles,
+	// which exceed ipc's 1 MiB default for large projects.
+	maxMessageSize = 64 * 1024 * 1024
+
+	// Messages over this many bytes are compressed, e.g. metafiles, when the
+	// backend supports compression
+	compressionThreshold = 64 * 1024
+
+	// The backend's entry point, relative to the current directory rather than
+	// the project directory
+	backendScript = "node/scripts/backend.esbuild.js"
+)
+
+// Describes a backend that stopped responding. The backend is killed before
+// the error is returned.
+type WedgedBackendError struct {
+	Action  string        // The last action sent, e.g. "rebuild"
+	Elapsed time.Duration // Time since the last action was sent
+	Tail    []string      // The last lines of stdout and stderr
+	Err     error         // Why the backend is considered wedged
+}
+
+func (e *WedgedBackendError) Error() string {
+	var str strings.Builder
+	fmt.Fprintf(&str, "backend stopped responding to %q after %s: %s", e.Action, e.Elapsed.Round(time.Millisecond), e.Err)
+	if len(e.Tail) > 0 {
+		str.WriteString("\n\n" + strings.Join(e.Tail, "\n"))
+	}
+	return str.String()
+}
+
+func (e *WedgedBackendError) Unwrap() error {
+	return e.Err
+}
+
+// An envelope and the size of the line it was decoded from
+type decoded struct {
+	message interface{}
+	size    int
+}
+
+// Supervises a backend process. Actions have deadlines and the backend is
+// pinged periodically so a wedged backend is detected and killed rather than
+// blocking forever.
+type supervisor struct {
+	process ipc.Transport
+	client  *ipc.Client
+
+	// Decoded envelopes; unencoded stdout lines are logged as they're read so
+	// the backend never blocks on stdout between actions
+	messages <-chan decoded
+	stderr   <-chan string
+
+	// Records how long actions take end to end and inside esbuild
+	metrics *ipc.Metrics
+
+	heartbeat     <-chan error
+	stopHeartbeat func()
+	actionTimeout time.Duration
+
+	lastAction   string
+	lastActionAt time.Time
+
+	mu   sync.Mutex
+	tail []string
+}
+
+// Starts the backend process. When RETRO_TRANSPORT is "socket", the backend
+// connects to a Unix socket that outlives restarts and external tools, e.g. an
+// editor extension, can attach to. When RETRO_PTY is "true", the backend runs
+// under a pseudo-terminal. RETRO_MEMORY_LIMIT limits the backend's heap so
+// runaway plugins fail the build rather than exhaust the machine's memory.
+func (r *RetroApp) startNode() (ipc.Transport, error) {
+	script, err := filepath.Abs(backendScript)
+	if err != nil {
+		return nil, fmt.Errorf("filepath.Abs: %w", err)
+	}
+	commandArgs := []string{"node", script}
+	options := []ipc.Option{
+		ipc.WithDir(r.Dir),
+		ipc.WithEnv(r.env...),
+		ipc.WithEnv(ipc.OfferCompression(compressionThreshold)...),
+		ipc.WithMaxMessageSize(maxMessageSize),
+	}
+	if RETRO_PTY == "true" {
+		// Plugins that check for a terminal keep their colors
+		options = append(options, ipc.WithPTY())
+	}
+	if mib := memoryLimit(); mib > 0 {
+		options = append(options, ipc.WithMaxOldSpaceSize(mib))
+	}
+
+	if RETRO_TRANSPORT != TransportSocket {
+		process, err := ipc.StartWithOptions(commandArgs, options...)
+		if err != nil {
+			return nil, fmt.Errorf("ipc.StartWithOptions: %w", err)
+		}
+		r.compressor = ipc.NewCompressor(process, compressionThreshold, maxMessageSize)
+		return r.compressor, nil
+	}
+
+	if r.listener == nil {
+		listener, err := ipc.Listen(ipc.WithMaxMessageSize(maxMessageSize))
+		if err != nil {
+			return nil, fmt.Errorf("ipc.Listen: %w", err)
+		}
+		r.mu.Lock()
+		r.listener = listener
+		r.mu.Unlock()
+		fmt.Println(terminal.Dim("Listening on " + listener.Path()))
+		go r.serveAttached(listener)
+	}
+	process, err := r.listener.Start(commandArgs, options...)
+	if err != nil {
+		return nil, fmt.Errorf("r.listener.Start: %w", err)
+	}
+	r.compressor = ipc.NewCompressor(process, compressionThreshold, maxMessageSize)
+	return r.compressor, nil
+}
+
+// Stops listening for the backend and external tools, if listening
+func (r *RetroApp) stopListening() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.listener != nil {
+		r.listener.Close()
+	}
+}
+
+// Replays the session recorded at RETRO_REPLAY instead of starting Node, e.g.
+// to reproduce a user's failing build
+func (r *RetroApp) startReplay() (ipc.Transport, error) {
+	file, err := os.Open(r.path(RETRO_REPLAY))
+	if err != nil {
+		return nil, fmt.Errorf("os.Open: %w", err)
+	}
+	defer file.Close()
+	records, err := ipc.ReadRecords(file)
+	if err != nil {
+		return nil, fmt.Errorf("ipc.ReadRecords: %w", err)
+	}
+	return ipc.NewReplay(records), nil
+}
+
+// Records the session with process to RETRO_RECORD. Sessions after a restart
+// are recorded next to it, e.g. to `session.2.jsonl`, so the session that
+// failed isn't overwritten.
+func (r *RetroApp) record(process ipc.Transport) (ipc.Transport, error) {
+	r.recordedSessions++
+	file, err := os.Create(sessionPath(r.path(RETRO_RECORD), r.recordedSessions))
+	if err != nil {
+		return nil, fmt.Errorf("os.Create: %w", err)
+	}
+	return ipc.NewRecorder(process, file), nil
+}
+
+// Returns the path of the nth recorded session, e.g. `session.2.jsonl` for
+// `session.jsonl`
+func sessionPath(path string, n int) string {
+	if n == 1 {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s.%d%s", strings.TrimSuffix(path, ext), n, ext)
+}
+
+// Supervises a started backend process and shakes hands with it. Plugins can
+// only call the host and backends are only pinged when the backend supports
+// JSON-RPC; backends that support heartbeats are pinged until stop or kill is
+// called. Messages to backends that support compression are compressed.
+func (r *RetroApp) newSupervisor(process ipc.Transport) (*supervisor, Backend, error) {
+	client := ipc.NewClient(process.Stdin(), process.Stdout())
+	// Heartbeats would drown out the actions
+	client.Use(r.metrics.Hooks(ipc.HeartbeatMethod))
+	client.Use(ipc.Hooks{AfterReceive: r.broadcast})
+
+	s := &supervisor{
+		process:       process,
+		client:        client,
+		stderr:        process.Stderr(),
+		metrics:       r.metrics,
+		stopHeartbeat: func() {},
+		actionTimeout: actionTimeout(),
+	}
+	backend, err := handshake(client.Stdout(), process.Stderr())
+	if err != nil {
+		process.Kill()
+		return nil, Backend{}, fmt.Errorf("handshake: %w", err)
+	}
+
+	if backend.Has(CapabilityJSONRPC) {
+		r.registerHandlers(client)
+	}
+	if backend.Has(CapabilityCompression) && r.compressor != nil {
+		r.compressor.Enable()
+	}
+
+	messages := make(chan decoded, 1)
+	s.messages = messages
+	go s.readLoop(messages)
+	if backend.Has(CapabilityJSONRPC) && backend.Has(CapabilityHeartbeat) {
+		s.heartbeat, s.stopHeartbeat = client.Heartbeat(heartbeatInterval, heartbeatTimeout)
+	}
+	return s, backend, nil
+}
+
+// Decodes envelopes and logs everything else. Closes messages when stdout is
+// closed.
+func (s *supervisor) readLoop(messages chan<- decoded) {
+	defer close(messages)
+	for line := range s.client.Stdout() {
+		if !isEnvelope([]byte(line)) {
+			s.record(line)
+			fmt.Println(decorateStdoutLine(line))
+			continue
+		}
+		message, err := Decode([]byte(line))
+		if err != nil {
+			message = fmt.Errorf("Decode: %w", err)
+		}
+		messages <- decoded{message: message, size: len(line)}
+	}
+}
+
+// Records backend output for WedgedBackendError
+func (s *supervisor) record(text string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tail = append(s.tail, strings.Split(text, "\n")...)
+	if len(s.tail) > outputTailLines {
+		s.tail = s.tail[len(s.tail)-outputTailLines:]
+	}
+}
+
+// Sends an action, e.g. "build"
+func (s *supervisor) send(action string) {
+	s.lastAction = action
+	s.lastActionAt = time.Now()
+	s.process.Stdin() <- action
+}
+
+// Waits for the response to the last action. Returns *WedgedBackendError and
+// kills the backend when the action times out or a heartbeat fails.
+func (s *supervisor) await() (interface{}, error) {
+	message, size, err := s.awaitDecoded()
+	s.observe(message, size, err)
+	return message, err
+}
+
+func (s *supervisor) awaitDecoded() (interface{}, int, error) {
+	// Zero disables the timeout; only heartbeats detect wedged backends
+	var timeoutC <-chan time.Time
+	if s.actionTimeout > 0 {
+		timeout := time.NewTimer(s.actionTimeout)
+		defer timeout.Stop()
+		timeoutC = timeout.C
+	}
+
+	for {
+		select {
+		case received, ok := <-s.messages:
+			if !ok {
+				return nil, 0, s.stopped()
+			}
+			if err, ok := received.message.(error); ok {
+				return nil, received.size, err
+			}
+			return received.message, received.size, nil
+		case text, ok := <-s.stderr:
+			if !ok {
+				s.stderr = nil
+				continue
+			}
+			s.record(text)
+			fmt.Println(decorateStderrText(text))
+			return nil, 0, errBackendStopped
+		case err := <-s.heartbeat:
+			return nil, 0, s.wedged(err)
+		case <-timeoutC:
+			return nil, 0, s.wedged(errTimeout)
+		}
+	}
+}
+
+// Records how long the last action took end to end and, for builds, how long
+// each bundle took inside esbuild, e.g. as "rebuild/esbuild/client"
+func (s *supervisor) observe(message interface{}, size int, err error) {
+	s.metrics.Observe(s.lastAction, time.Since(s.lastActionAt), len(s.lastAction), size, err)
+
+	bundles := map[string]BundleResult{}
+	switch message := message.(type) {
+	case BuildDoneMessage:
+		bundles["vendor"] = message.Data.Vendor
+		bundles["client"] = message.Data.Client
+	case BuildClientDoneMessage:
+		bundles["client"] = message.Data.Client
+	case RebuildDoneMessage:
+		bundles["client"] = message.Data.Client
+	}
+	for name, bundle := range bundles {
+		if bundle.Duration == 0 {
+			continue
+		}
+		elapsed := time.Duration(bundle.Duration * float64(time.Millisecond))
+		var bundleErr error
+		if len(bundle.Errors) > 0 {
+			bundleErr = errBundleFailed
+		}
+		s.metrics.Observe(s.lastAction+"/esbuild/"+name, elapsed, 0, 0, bundleErr)
+	}
+}
+
+// Describes why stdout closed. Read errors, e.g. an oversized message, close
+// stdout while the backend is still running so it's killed.
+func (s *supervisor) stopped() error {
+	if process, ok := s.process.(interface{ Err() error }); ok {
+		if err := process.Err(); err != nil {
+			s.kill()
+			return fmt.Errorf("backend: %w", err)
+		}
+	}
+	return errBackendStopped
+}
+
+// Kills the backend and describes what it was doing
+func (s *supervisor) wedged(err error) error {
+	s.kill()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &WedgedBackendError{
+		Action:  s.lastAction,
+		Elapsed: time.Since(s.lastActionAt),
+		Tail:    append([]string(nil), s.tail...),
+		Err:     err,
+	}
+}
+
+// Kills the backend and waits for it to exit so stderr is part of the tail
+func (s *supervisor) kill() {
+	s.stopHeartbeat()
+	s.process.Kill()
+	go func() {
+		// Drain stdout so the process can be reaped
+		for range s.messages {
+		}
+	}()
+	if s.stderr != nil {
+		if text, ok := <-s.stderr; ok {
+			s.record(text)
+		}
+	}
+	s.process.Wait()
+}
+
+// Stops the backend gracefully
+func (s *supervisor) stop() {
+	s.stopHeartbeat()
+	s.process.Stdin() <- "done"
+}
@@ -0,0 +1,316 @@
+package retro
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zaydek/go-ipc-test/go/pkg/expect"
+	"github.com/zaydek/go-ipc-test/go/pkg/ipc"
+	"github.com/zaydek/go-ipc-test/go/pkg/ipc/ipctest"
+)
+
+func helloLine(capabilities ...string) string {
+	return fmt.Sprintf(`{"Kind":"hello","Data":{"ProtocolVersion":%d,"Capabilities":["%s"]}}`,
+		ProtocolVersion, strings.Join(capabilities, `","`))
+}
+
+// Returns an app whose backends are fake processes, in order. Starting more
+// backends than there are processes, e.g. an unexpected restart, fails the test.
+func newTestApp(t *testing.T, processes ...ipc.Transport) *RetroApp {
+	return &RetroApp{
+		metrics: ipc.NewMetrics(),
+		StartBackend: func() (ipc.Transport, error) {
+			if len(processes) == 0 {
+				t.Fatalf("StartBackend: no more test processes")
+			}
+			process := processes[0]
+			processes = processes[1:]
+			return process, nil
+		},
+	}
+}
+
+// Starts the first of processes as the backend of a new test app. The backend
+// is stopped when the test finishes.
+func startTestBackend(t *testing.T, processes ...ipc.Transport) (*RetroApp, *supervisor) {
+	r := newTestApp(t, processes...)
+	s, err := r.startBackend()
+	if err != nil {
+		t.Fatalf("r.startBackend: %s", err)
+	}
+	t.Cleanup(s.stop)
+	return r, s
+}
+
+func TestBuildBundles(t *testing.T) {
+	tests := []struct {
+		name         string
+		respond      ipctest.Responder
+		capabilities []string
+		compress     bool
+		wantErr      string // A prefix of the error; empty when the build succeeds
+		wantReceived []string
+	}{
+		{
+			name: "build",
+			respond: ipctest.Script(map[string][]string{
+				"vendor_info": {`{"Kind":"vendor_info","Data":{"Modules":["react"],"EsbuildVersion":"0.13.2"}}`},
+				"build":       {"compiling", `{"Kind":"build_done","Data":{}}`},
+			}),
+			capabilities: []string{CapabilityVendorCache},
+			wantReceived: []string{"vendor_info", "build"},
+		},
+		{
+			name: "backend stopped",
+			respond: func(p *ipctest.Process, line string) {
+				if line == "build" {
+					p.Exit("TypeError: Cannot read property 'foo' of undefined")
+				}
+			},
+			wantErr:      errBackendStopped.Error(),
+			wantReceived: []string{"build"},
+		},
+		{
+			// Fails as soon as the message is read rather than when the action
+			// times out
+			name: "corrupt message",
+			respond: ipctest.Script(map[string][]string{
+				"build": {"\x1egzip:foo"},
+			}),
+			compress:     true,
+			wantErr:      "backend: DecompressLine: ",
+			wantReceived: []string{"build"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			process := ipctest.NewProcess(test.respond, helloLine(test.capabilities...))
+			var transport ipc.Transport = process
+			if test.compress {
+				transport = ipc.NewCompressor(process, compressionThreshold, maxMessageSize)
+			}
+			r, s := startTestBackend(t, transport)
+
+			message, err := r.buildBundles(s)
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatalf("r.buildBundles: %s", err)
+				}
+				_, ok := message.(BuildDoneMessage)
+				expect.DeepEqual(t, ok, true)
+			} else if err == nil || !strings.HasPrefix(err.Error(), test.wantErr) {
+				t.Fatalf("r.buildBundles: got %v want %q", err, test.wantErr)
+			}
+			expect.DeepEqual(t, process.Received(), test.wantReceived)
+		})
+	}
+}
+
+func TestAwaitWedged(t *testing.T) {
+	process := ipctest.NewProcess(func(p *ipctest.Process, line string) {
+		if line == "build" {
+			p.WriteStdout("compiling")
+		}
+	}, helloLine())
+
+	r, s := startTestBackend(t, process)
+	s.actionTimeout = 50 * time.Millisecond
+
+	_, err := r.buildBundles(s)
+	var wedged *WedgedBackendError
+	if !errors.As(err, &wedged) {
+		t.Fatalf("r.buildBundles: got %v want *WedgedBackendError", err)
+	}
+	expect.DeepEqual(t, wedged.Action, "build")
+	expect.DeepEqual(t, wedged.Tail, []string{"compiling"})
+	expect.DeepEqual(t, wedged.Err, errTimeout)
+	expect.DeepEqual(t, process.Wait(), ipctest.ErrKilled)
+}
+
+func TestAwaitNoTimeout(t *testing.T) {
+	RETRO_ACTION_TIMEOUT = "0"
+	t.Cleanup(func() { RETRO_ACTION_TIMEOUT = "" })
+
+	process := ipctest.NewProcess(func(p *ipctest.Process, line string) {
+		if line == "build" {
+			go func() {
+				time.Sleep(50 * time.Millisecond)
+				p.WriteStdout(`{"Kind":"build_done","Data":{}}`)
+			}()
+		}
+	}, helloLine())
+
+	r, s := startTestBackend(t, process)
+	expect.DeepEqual(t, s.actionTimeout, time.Duration(0))
+
+	if _, err := r.buildBundles(s); err != nil {
+		t.Fatalf("r.buildBundles: %s", err)
+	}
+}
+
+func TestSupervisorMetrics(t *testing.T) {
+	process := ipctest.NewProcess(ipctest.Script(map[string][]string{
+		"build": {`{"Kind":"build_done","Data":{"Vendor":{"Duration":0},"Client":{"Duration":12.5}}}`},
+	}), helloLine())
+
+	r, s := startTestBackend(t, process)
+	if _, err := r.buildBundles(s); err != nil {
+		t.Fatalf("r.buildBundles: %s", err)
+	}
+
+	var str strings.Builder
+	if err := r.metrics.WritePrometheus(&str); err != nil {
+		t.Fatalf("r.metrics.WritePrometheus: %s", err)
+	}
+	metrics := str.String()
+	expect.DeepEqual(t, strings.Contains(metrics, `ipc_action_duration_seconds_count{action="build"} 1`), true)
+	expect.DeepEqual(t, strings.Contains(metrics, `ipc_action_duration_seconds_sum{action="build/esbuild/client"} 0.0125`), true)
+	// Cached or missing durations aren't observed
+	expect.DeepEqual(t, strings.Contains(metrics, `build/esbuild/vendor`), false)
+}
+
+func TestShutdown(t *testing.T) {
+	process := ipctest.NewProcess(nil, helloLine())
+
+	r := newTestApp(t, process)
+	if _, err := r.startBackend(); err != nil {
+		t.Fatalf("r.startBackend: %s", err)
+	}
+	r.shutdown()
+	expect.DeepEqual(t, process.Wait(), ipctest.ErrKilled)
+}
+
+func TestDevLoop(t *testing.T) {
+	wedged := ipctest.NewProcess(ipctest.Script(map[string][]string{
+		"rebuild": {`{"Kind":"rebuild_done","Data":{}}`},
+	}), helloLine(CapabilityReload))
+	restarted := ipctest.NewProcess(ipctest.Script(map[string][]string{
+		"build":   {`{"Kind":"build_done","Data":{}}`},
+		"rebuild": {`{"Kind":"rebuild_done","Data":{}}`},
+	}), helloLine(CapabilityReload))
+
+	r, s := startTestBackend(t, wedged, restarted)
+	s.actionTimeout = 50 * time.Millisecond
+
+	changes := make(chan []string, 3)
+	changes <- []string{"src/App.js"}
+	changes <- []string{"retro.config.js"} // Never responded to
+	changes <- []string{"src/App.js"}
+	close(changes)
+
+	s, err := r.devLoop(s, changes)
+	if err != nil {
+		t.Fatalf("r.devLoop: %s", err)
+	}
+	defer s.stop()
+
+	expect.DeepEqual(t, wedged.Received(), []string{"rebuild", "reload"})
+	expect.DeepEqual(t, restarted.Received(), []string{"build", "rebuild"})
+}
+
+// Returns the host's response to a plugin calling "env" during a build
+func callEnvDuringBuild(t *testing.T, capabilities ...string) string {
+	process := ipctest.NewProcess(func(p *ipctest.Process, line string) {
+		if line == "build" {
+			p.WriteStdout(`{"jsonrpc":"2.0","id":1,"method":"env"}`)
+		} else if strings.Contains(line, `"jsonrpc"`) {
+			p.WriteStdout(`{"Kind":"build_done","Data":{}}`)
+		}
+	}, helloLine(capabilities...))
+
+	r, s := startTestBackend(t, process)
+	if _, err := r.buildBundles(s); err != nil {
+		t.Fatalf("r.buildBundles: %s", err)
+	}
+	return process.Received()[1]
+}
+
+func TestSupervisorJSONRPC(t *testing.T) {
+	expect.DeepEqual(t, strings.Contains(callEnvDuringBuild(t, CapabilityJSONRPC), `"result"`), true)
+
+	// Plugins can't call backends that didn't negotiate JSON-RPC
+	expect.DeepEqual(t, strings.Contains(callEnvDuringBuild(t), `"code":-32601`), true)
+}
+
+func TestSessionPath(t *testing.T) {
+	expect.DeepEqual(t, sessionPath("session.jsonl", 1), "session.jsonl")
+	expect.DeepEqual(t, sessionPath("session.jsonl", 2), "session.2.jsonl")
+	expect.DeepEqual(t, sessionPath("session", 3), "session.3")
+}
+
+// Returns a project directory with empty entry points
+func newTestProject(t *testing.T) string {
+	dir := t.TempDir()
+	for _, path := range []string{"www/index.html", "src/index.js", "src/App.js"} {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(path)), permDir); err != nil {
+			t.Fatalf("os.MkdirAll: %s", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, path), nil, permFile); err != nil {
+			t.Fatalf("os.WriteFile: %s", err)
+		}
+	}
+	return dir
+}
+
+func TestBuildReplayMismatch(t *testing.T) {
+	dir := newTestProject(t)
+
+	// Recorded when the host sent "rebuild" rather than "build"
+	file, err := os.Create(filepath.Join(dir, "session.jsonl"))
+	if err != nil {
+		t.Fatalf("os.Create: %s", err)
+	}
+	encoder := json.NewEncoder(file)
+	encoder.Encode(ipc.Record{Direction: ipc.DirectionStdout, Payload: helloLine(CapabilityVendorCache)})
+	encoder.Encode(ipc.Record{Direction: ipc.DirectionStdin, Payload: "rebuild"})
+	file.Close()
+
+	t.Setenv("RETRO_REPLAY", "session.jsonl")
+	t.Cleanup(func() { RETRO_REPLAY = "" })
+
+	r := &RetroApp{Dir: dir}
+	err = r.Build()
+	var mismatch *ipc.ReplayMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("r.Build: got %v want *ipc.ReplayMismatchError", err)
+	}
+	expect.DeepEqual(t, *mismatch, ipc.ReplayMismatchError{Want: "rebuild", Got: "build"})
+}
+
+func TestBuildConfigurationError(t *testing.T) {
+	process := ipctest.NewProcess(func(p *ipctest.Process, line string) {
+		switch line {
+		case "build":
+			p.WriteStdout(`{"Kind":"configuration_error","Data":{"Errors":[]}}`)
+		case "done":
+			p.Exit("")
+		}
+	}, helloLine())
+
+	r := newTestApp(t, process)
+	r.Dir = newTestProject(t)
+	expect.DeepEqual(t, r.Build(), ErrConfiguration)
+	// The backend is stopped rather than orphaned
+	process.Wait()
+	expect.DeepEqual(t, process.Received(), []string{"build", "done"})
+}
+
+func TestMemoryLimit(t *testing.T) {
+	t.Cleanup(func() { RETRO_MEMORY_LIMIT = "" })
+	r := &RetroApp{Dir: t.TempDir()}
+
+	t.Setenv("RETRO_MEMORY_LIMIT", "2048")
+	if err := r.setEnvsAndGlobalVariables(ModeBuild); err != nil {
+		t.Fatalf("r.setEnvsAndGlobalVariables: %s", err)
+	}
+	expect.DeepEqual(t, memoryLimit(), 2048)
+
+	t.Setenv("RETRO_MEMORY_LIMIT", "2GB")
+	expect.DeepEqual(t, r.setEnvsAndGlobalVariables(ModeBuild) != nil, true)
+}
@@ -1,11 +1,50 @@
 package retro
 
-import "github.com/evanw/esbuild/pkg/api"
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+// Message kinds; `node/scripts/backend/types.ts` is generated from MessageTypes
+const (
+	KindBuildDone          = "build_done"
+	KindRebuildDone        = "rebuild_done"
+	KindBuildClientDone    = "build_client_done"
+	KindConfigurationError = "configuration_error"
+	KindVendorInfo         = "vendor_info"
+	KindHello              = "hello"
+)
+
+// The version of the protocol between the Go host and the Node backend. Bump
+// when messages or actions change incompatibly.
+const ProtocolVersion = 1
+
+// Features the backend can support; the negotiated capabilities are the ones
+// both the host and the backend support
+const (
+	CapabilityReload      = "reload"       // The "reload" action
+	CapabilityVendorCache = "vendor_cache" // The "vendor_info" and "build_client" actions
+	CapabilityJSONRPC     = "jsonrpc"      // JSON-RPC calls from plugins to the host
+	CapabilityHeartbeat   = "heartbeat"    // The "ping" JSON-RPC method
+	CapabilityCompression = "compression"  // Compressed messages to the backend; see ipc.Compressor
+)
+
+var hostCapabilities = []string{
+	CapabilityReload,
+	CapabilityVendorCache,
+	CapabilityJSONRPC,
+	CapabilityHeartbeat,
+	CapabilityCompression,
+}
 
 type BundleResult struct {
 	Metafile map[string]interface{}
 	Warnings []api.Message
 	Errors   []api.Message
+	Duration float64 // Milliseconds spent in esbuild; zero when cached
 }
 
 type BuildDoneMessage struct {
@@ -22,3 +61,90 @@ type RebuildDoneMessage struct {
 		Client BundleResult
 	}
 }
+
+type ConfigurationErrorMessage struct {
+	Kind string
+	Data struct {
+		Errors []api.Message
+	}
+}
+
+// Describes the inputs of the vendor bundle, as reported by the backend
+type VendorInfoMessage struct {
+	Kind string
+	Data struct {
+		Modules        []string
+		EsbuildVersion string
+	}
+}
+
+type BuildClientDoneMessage struct {
+	Kind string
+	Data struct {
+		Client BundleResult
+	}
+}
+
+// Sent by the backend on startup, before any action
+type HelloMessage struct {
+	Kind string
+	Data struct {
+		ProtocolVersion int
+		EsbuildVersion  string
+		NodeVersion     string
+		Capabilities    []string
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Describes any message from the backend before it's decoded
+type Envelope struct {
+	Kind string
+	Data json.RawMessage
+}
+
+// Maps message kinds to their Go types
+var MessageTypes = map[string]reflect.Type{
+	KindBuildDone:          reflect.TypeOf(BuildDoneMessage{}),
+	KindRebuildDone:        reflect.TypeOf(RebuildDoneMessage{}),
+	KindBuildClientDone:    reflect.TypeOf(BuildClientDoneMessage{}),
+	KindConfigurationError: reflect.TypeOf(ConfigurationErrorMessage{}),
+	KindVendorInfo:         reflect.TypeOf(VendorInfoMessage{}),
+	KindHello:              reflect.TypeOf(HelloMessage{}),
+}
+
+// Describes a message whose kind is not in MessageTypes, e.g. when
+// `backend.esbuild.js` is newer or older than the Go binary
+type UnknownKindError struct {
+	Kind string
+}
+
+func (e *UnknownKindError) Error() string {
+	return fmt.Sprintf("unknown message kind %q", e.Kind)
+}
+
+// Returns whether line is an envelope, i.e. a JSON object with a kind. Other
+// lines are logs.
+func isEnvelope(line []byte) bool {
+	var envelope Envelope
+	return json.Unmarshal(line, &envelope) == nil && envelope.Kind != ""
+}
+
+// Decodes a message into the concrete type registered for its kind, e.g.
+// BuildDoneMessage. Returns an *UnknownKindError for unregistered kinds.
+func Decode(line []byte) (interface{}, error) {
+	var envelope Envelope
+	if err := json.Unmarshal(line, &envelope); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal: %w", err)
+	}
+	messageType, ok := MessageTypes[envelope.Kind]
+	if !ok {
+		return nil, &UnknownKindError{Kind: envelope.Kind}
+	}
+	message := reflect.New(messageType)
+	if err := json.Unmarshal(line, message.Interface()); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal: %w", err)
+	}
+	return message.Elem().Interface(), nil
+}
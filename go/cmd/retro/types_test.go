@@ -0,0 +1,39 @@
+package retro
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zaydek/go-ipc-test/go/pkg/expect"
+)
+
+func TestDecode(t *testing.T) {
+	message, err := Decode([]byte(`{"Kind":"rebuild_done","Data":{"Client":{"Metafile":null,"Warnings":[],"Errors":[{"text":"Could not resolve \"foo\""}]}}}`))
+	if err != nil {
+		t.Fatalf("Decode: %s", err)
+	}
+	rebuildDone, ok := message.(RebuildDoneMessage)
+	if !ok {
+		t.Fatalf("Decode: got %T want RebuildDoneMessage", message)
+	}
+	expect.DeepEqual(t, rebuildDone.Data.Client.Errors[0].Text, `Could not resolve "foo"`)
+}
+
+func TestDecodeUnknownKind(t *testing.T) {
+	_, err := Decode([]byte(`{"Kind":"foo","Data":{}}`))
+	unknownKindErr, ok := err.(*UnknownKindError)
+	if !ok {
+		t.Fatalf("Decode: got %v want *UnknownKindError", err)
+	}
+	expect.DeepEqual(t, unknownKindErr.Kind, "foo")
+}
+
+func TestDecodeRegistry(t *testing.T) {
+	for kind, messageType := range MessageTypes {
+		message, err := Decode([]byte(`{"Kind":"` + kind + `","Data":{}}`))
+		if err != nil {
+			t.Fatalf("Decode: %s", err)
+		}
+		expect.DeepEqual(t, reflect.TypeOf(message), messageType)
+	}
+}
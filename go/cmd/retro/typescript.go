@@ -0,0 +1,52 @@
+package retro
+
+//go:generate go run ../gen_types -o ../../../node/scripts/backend/types.ts
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+
+	"github.com/evanw/esbuild/pkg/api"
+	"github.com/zaydek/go-ipc-test/go/pkg/tsgen"
+)
+
+// Generates `node/scripts/backend/types.ts` from MessageTypes so the Go and
+// TypeScript protocol types can't drift
+func GenerateTypeScript(w io.Writer) error {
+	var kinds []string
+	for kind := range MessageTypes {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	var declarations []tsgen.Declaration
+	for _, kind := range kinds {
+		declarations = append(declarations, tsgen.Declaration{
+			Type:     MessageTypes[kind],
+			Literals: map[string]string{"Kind": kind},
+		})
+	}
+
+	fmt.Fprint(w, "// Code generated by `go generate ./go/cmd/retro`; DO NOT EDIT.\n\n")
+	fmt.Fprint(w, "import * as esbuild from \"esbuild\"\n\n")
+
+	generator := &tsgen.Generator{
+		Overrides: map[reflect.Type]string{
+			// esbuild messages are encoded by esbuild, not Go
+			reflect.TypeOf(api.Message{}): "esbuild.Message",
+		},
+	}
+	if err := generator.Generate(w, declarations); err != nil {
+		return fmt.Errorf("generator.Generate: %w", err)
+	}
+
+	fmt.Fprintf(w, "\n// The version of the protocol between the Go host and the Node backend\nexport const PROTOCOL_VERSION = %d\n", ProtocolVersion)
+
+	fmt.Fprint(w, "\n// Messages sent from the backend\nexport type Message =\n")
+	for _, kind := range kinds {
+		fmt.Fprintf(w, "\t| %s\n", MessageTypes[kind].Name())
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+package retro
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestTypeScriptUpToDate(t *testing.T) {
+	var buf bytes.Buffer
+	if err := GenerateTypeScript(&buf); err != nil {
+		t.Fatalf("GenerateTypeScript: %s", err)
+	}
+	checkedIn, err := os.ReadFile("../../../node/scripts/backend/types.ts")
+	if err != nil {
+		t.Fatalf("os.ReadFile: %s", err)
+	}
+	if !bytes.Equal(buf.Bytes(), checkedIn) {
+		t.Fatal("node/scripts/backend/types.ts is out of date; run `go generate ./go/cmd/retro`")
+	}
+}
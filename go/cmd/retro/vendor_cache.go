@@ -0,0 +1,222 @@
+package retro
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/zaydek/go-ipc-test/go/pkg/terminal"
+)
+
+// The lockfiles of npm, yarn, and pnpm, in order of precedence
+var lockfiles = []string{"package-lock.json", "yarn.lock", "pnpm-lock.yaml"}
+
+// Hashes everything that affects the vendor bundle. Returns an empty key when
+// there is no lockfile in dir, in which case the vendor bundle is not cached.
+func vendorCacheKey(dir string, vendorInfo VendorInfoMessage) (string, error) {
+	var name string
+	var lockfile []byte
+	for _, candidate := range lockfiles {
+		byteStr, err := os.ReadFile(filepath.Join(dir, candidate))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return "", fmt.Errorf("os.ReadFile: %w", err)
+		}
+		name, lockfile = candidate, byteStr
+		break
+	}
+	if name == "" {
+		return "", nil
+	}
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\x00", name)
+	hash.Write(lockfile)
+	fmt.Fprintf(hash, "\x00%s", strings.Join(vendorInfo.Data.Modules, ","))
+	fmt.Fprintf(hash, "\x00%s", NODE_ENV)
+	fmt.Fprintf(hash, "\x00%s", vendorInfo.Data.EsbuildVersion)
+	fmt.Fprintf(hash, "\x00%s", RETRO_OUT_DIR)
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func copyFile(src, dst string) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("os.Open: %w", err)
+	}
+	defer srcFile.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), permDir); err != nil {
+		return fmt.Errorf("os.MkdirAll: %w", err)
+	}
+	dstFile, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, permFile)
+	if err != nil {
+		return fmt.Errorf("os.OpenFile: %w", err)
+	}
+	defer dstFile.Close()
+
+	if _, err := io.Copy(dstFile, srcFile); err != nil {
+		return fmt.Errorf("io.Copy: %w", err)
+	}
+	return nil
+}
+
+// Returns the output paths of an esbuild metafile, e.g. `out/vendor.js`
+func metafileOutputs(metafile map[string]interface{}) []string {
+	outputs, _ := metafile["outputs"].(map[string]interface{})
+	var paths []string
+	for path := range outputs {
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// Restores the cached vendor bundle to the out directory in dir, along with
+// its warnings. Returns false when nothing is cached for key or the cache entry
+// is corrupt or partially evicted.
+func restoreVendorBundle(dir, key string) (BundleResult, bool, error) {
+	var vendor BundleResult
+
+	cacheDir := filepath.Join(dir, vendorCacheDir, key)
+	byteStr, err := os.ReadFile(filepath.Join(cacheDir, "metafile.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return BundleResult{}, false, nil
+		}
+		return BundleResult{}, false, fmt.Errorf("os.ReadFile: %w", err)
+	}
+	if err := json.Unmarshal(byteStr, &vendor.Metafile); err != nil {
+		// Treat corrupt caches as cache misses; they're overwritten
+		return BundleResult{}, false, nil
+	}
+	byteStr, err = os.ReadFile(filepath.Join(cacheDir, "warnings.json"))
+	if err != nil {
+		// Entries cached before warnings were stored are misses
+		if os.IsNotExist(err) {
+			return BundleResult{}, false, nil
+		}
+		return BundleResult{}, false, fmt.Errorf("os.ReadFile: %w", err)
+	}
+	if err := json.Unmarshal(byteStr, &vendor.Warnings); err != nil {
+		return BundleResult{}, false, nil
+	}
+
+	for _, path := range metafileOutputs(vendor.Metafile) {
+		if err := copyFile(filepath.Join(cacheDir, path), filepath.Join(dir, path)); err != nil {
+			// Treat partially evicted caches as cache misses
+			if errors.Is(err, os.ErrNotExist) {
+				return BundleResult{}, false, nil
+			}
+			return BundleResult{}, false, fmt.Errorf("copyFile: %w", err)
+		}
+	}
+	return vendor, true, nil
+}
+
+// Caches the vendor bundle's outputs in dir and its metafile and warnings for
+// key
+func cacheVendorBundle(dir, key string, vendor BundleResult) error {
+	cacheDir := filepath.Join(dir, vendorCacheDir, key)
+	if err := os.RemoveAll(cacheDir); err != nil {
+		return fmt.Errorf("os.RemoveAll: %w", err)
+	}
+
+	for _, path := range metafileOutputs(vendor.Metafile) {
+		if err := copyFile(filepath.Join(dir, path), filepath.Join(cacheDir, path)); err != nil {
+			return fmt.Errorf("copyFile: %w", err)
+		}
+	}
+
+	byteStr, err := json.Marshal(vendor.Warnings)
+	if err != nil {
+		return fmt.Errorf("json.Marshal: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(cacheDir, "warnings.json"), byteStr, permFile); err != nil {
+		return fmt.Errorf("os.WriteFile: %w", err)
+	}
+
+	// Write the metafile last so partially written caches are never restored
+	byteStr, err = json.Marshal(vendor.Metafile)
+	if err != nil {
+		return fmt.Errorf("json.Marshal: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(cacheDir, "metafile.json"), byteStr, permFile); err != nil {
+		return fmt.Errorf("os.WriteFile: %w", err)
+	}
+	return nil
+}
+
+// Builds the vendor and client bundles, restoring the vendor bundle from cache
+// when its inputs haven't changed. Responses are normalized to BuildDoneMessage
+// so callers don't need to know whether the vendor bundle was cached. dir is the
+// project directory.
+func buildWithVendorCache(s *supervisor, dir string) (interface{}, error) {
+	s.send("vendor_info")
+	message, err := s.await()
+	if err != nil {
+		return nil, fmt.Errorf("s.await: %w", err)
+	}
+	vendorInfo, ok := message.(VendorInfoMessage)
+	if !ok {
+		return message, nil
+	}
+
+	key, err := vendorCacheKey(dir, vendorInfo)
+	if err != nil {
+		return nil, fmt.Errorf("vendorCacheKey: %w", err)
+	}
+
+	var vendor BundleResult
+	var cached bool
+	if key == "" {
+		fmt.Println(terminal.Dim("No lockfile found; the vendor bundle isn't cached"))
+	} else {
+		if vendor, cached, err = restoreVendorBundle(dir, key); err != nil {
+			return nil, fmt.Errorf("restoreVendorBundle: %w", err)
+		}
+	}
+
+	// Cache miss; build and cache the vendor bundle
+	if !cached {
+		s.send("build")
+		message, err := s.await()
+		if err != nil {
+			return nil, fmt.Errorf("s.await: %w", err)
+		}
+		buildDone, ok := message.(BuildDoneMessage)
+		if !ok || key == "" {
+			return message, nil
+		}
+		if len(buildDone.Data.Vendor.Errors) == 0 {
+			if err := cacheVendorBundle(dir, key, buildDone.Data.Vendor); err != nil {
+				return nil, fmt.Errorf("cacheVendorBundle: %w", err)
+			}
+		}
+		return buildDone, nil
+	}
+
+	// Cache hit; build the client bundle
+	s.send("build_client")
+	message, err = s.await()
+	if err != nil {
+		return nil, fmt.Errorf("s.await: %w", err)
+	}
+	buildClientDone, ok := message.(BuildClientDoneMessage)
+	if !ok {
+		return message, nil
+	}
+
+	var buildDone BuildDoneMessage
+	buildDone.Kind = KindBuildDone
+	buildDone.Data.Vendor = vendor
+	buildDone.Data.Client = buildClientDone.Data.Client
+	return buildDone, nil
+}
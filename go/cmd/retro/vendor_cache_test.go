@@ -0,0 +1,205 @@
+package retro
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zaydek/go-ipc-test/go/pkg/expect"
+	"github.com/zaydek/go-ipc-test/go/pkg/ipc/ipctest"
+)
+
+const (
+	vendorInfoLine      = `{"Kind":"vendor_info","Data":{"Modules":["react"],"EsbuildVersion":"0.13.2"}}`
+	buildDoneLine       = `{"Kind":"build_done","Data":{"Vendor":{"Metafile":{"outputs":{"out/vendor.js":{}}},"Warnings":[{"Text":"deprecated"}]}}}`
+	buildClientDoneLine = `{"Kind":"build_client_done","Data":{"Client":{"Metafile":{"outputs":{"out/client.js":{}}}}}}`
+)
+
+// Builds the bundles in dir with a fake backend that writes `out/vendor.js`
+// on "build" and returns the actions it received
+func buildVendorCached(t *testing.T, dir string) []string {
+	process := ipctest.NewProcess(func(p *ipctest.Process, line string) {
+		switch line {
+		case "vendor_info":
+			p.WriteStdout(vendorInfoLine)
+		case "build":
+			if err := os.MkdirAll(filepath.Join(dir, "out"), permDir); err != nil {
+				t.Errorf("os.MkdirAll: %s", err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, "out/vendor.js"), []byte("vendor"), permFile); err != nil {
+				t.Errorf("os.WriteFile: %s", err)
+			}
+			p.WriteStdout(buildDoneLine)
+		case "build_client":
+			p.WriteStdout(buildClientDoneLine)
+		}
+	}, helloLine(CapabilityVendorCache))
+
+	r, s := startTestBackend(t, process)
+	r.Dir = dir
+
+	message, err := r.buildBundles(s)
+	if err != nil {
+		t.Fatalf("r.buildBundles: %s", err)
+	}
+	buildDone, ok := message.(BuildDoneMessage)
+	expect.DeepEqual(t, ok, true)
+	expect.DeepEqual(t, metafileOutputs(buildDone.Data.Vendor.Metafile), []string{"out/vendor.js"})
+	// Warnings are replayed on cache hits
+	expect.DeepEqual(t, len(buildDone.Data.Vendor.Warnings), 1)
+	expect.DeepEqual(t, buildDone.Data.Vendor.Warnings[0].Text, "deprecated")
+	return process.Received()
+}
+
+func TestVendorCache(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "package-lock.json"), []byte("{}"), permFile); err != nil {
+		t.Fatalf("os.WriteFile: %s", err)
+	}
+
+	// Misses build and cache the vendor bundle
+	expect.DeepEqual(t, buildVendorCached(t, dir), []string{"vendor_info", "build"})
+
+	// Hits restore the vendor bundle and only build the client bundle
+	if err := os.Remove(filepath.Join(dir, "out/vendor.js")); err != nil {
+		t.Fatalf("os.Remove: %s", err)
+	}
+	expect.DeepEqual(t, buildVendorCached(t, dir), []string{"vendor_info", "build_client"})
+	byteStr, err := os.ReadFile(filepath.Join(dir, "out/vendor.js"))
+	if err != nil {
+		t.Fatalf("os.ReadFile: %s", err)
+	}
+	expect.DeepEqual(t, string(byteStr), "vendor")
+
+	// Changing the lockfile changes the key
+	if err := os.WriteFile(filepath.Join(dir, "package-lock.json"), []byte(`{"version":2}`), permFile); err != nil {
+		t.Fatalf("os.WriteFile: %s", err)
+	}
+	expect.DeepEqual(t, buildVendorCached(t, dir), []string{"vendor_info", "build"})
+	expect.DeepEqual(t, buildVendorCached(t, dir), []string{"vendor_info", "build_client"})
+}
+
+func TestVendorCacheCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "yarn.lock"), []byte("# yarn lockfile v1"), permFile); err != nil {
+		t.Fatalf("os.WriteFile: %s", err)
+	}
+	expect.DeepEqual(t, buildVendorCached(t, dir), []string{"vendor_info", "build"})
+
+	entries, err := filepath.Glob(filepath.Join(dir, vendorCacheDir, "*"))
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("filepath.Glob: %v %s", entries, err)
+	}
+	cacheDir := entries[0]
+
+	// Corrupt metafiles are cache misses
+	if err := os.WriteFile(filepath.Join(cacheDir, "metafile.json"), []byte("{"), permFile); err != nil {
+		t.Fatalf("os.WriteFile: %s", err)
+	}
+	expect.DeepEqual(t, buildVendorCached(t, dir), []string{"vendor_info", "build"})
+
+	// Partially evicted entries are cache misses
+	if err := os.Remove(filepath.Join(cacheDir, "out/vendor.js")); err != nil {
+		t.Fatalf("os.Remove: %s", err)
+	}
+	expect.DeepEqual(t, buildVendorCached(t, dir), []string{"vendor_info", "build"})
+	expect.DeepEqual(t, buildVendorCached(t, dir), []string{"vendor_info", "build_client"})
+}
+
+func TestVendorCacheNoLockfile(t *testing.T) {
+	dir := t.TempDir()
+	expect.DeepEqual(t, buildVendorCached(t, dir), []string{"vendor_info", "build"})
+	expect.DeepEqual(t, buildVendorCached(t, dir), []string{"vendor_info", "build"})
+}
+
+func TestVendorCacheKey(t *testing.T) {
+	dir := t.TempDir()
+
+	var vendorInfo VendorInfoMessage
+	vendorInfo.Data.Modules = []string{"react"}
+	vendorInfo.Data.EsbuildVersion = "0.13.2"
+
+	// No lockfile; the vendor bundle isn't cached
+	key, err := vendorCacheKey(dir, vendorInfo)
+	if err != nil {
+		t.Fatalf("vendorCacheKey: %s", err)
+	}
+	expect.DeepEqual(t, key, "")
+
+	if err := os.WriteFile(filepath.Join(dir, "package-lock.json"), []byte("{}"), permFile); err != nil {
+		t.Fatalf("os.WriteFile: %s", err)
+	}
+	key, err = vendorCacheKey(dir, vendorInfo)
+	if err != nil {
+		t.Fatalf("vendorCacheKey: %s", err)
+	}
+	expect.DeepEqual(t, key != "", true)
+
+	// Changing the lockfile or the vendor modules changes the key
+	if err := os.WriteFile(filepath.Join(dir, "package-lock.json"), []byte(`{"lockfileVersion":2}`), permFile); err != nil {
+		t.Fatalf("os.WriteFile: %s", err)
+	}
+	lockfileKey, err := vendorCacheKey(dir, vendorInfo)
+	if err != nil {
+		t.Fatalf("vendorCacheKey: %s", err)
+	}
+	expect.DeepEqual(t, lockfileKey != key, true)
+
+	vendorInfo.Data.Modules = []string{"react", "react-dom"}
+	modulesKey, err := vendorCacheKey(dir, vendorInfo)
+	if err != nil {
+		t.Fatalf("vendorCacheKey: %s", err)
+	}
+	expect.DeepEqual(t, modulesKey != lockfileKey, true)
+}
+
+func TestCacheAndRestoreVendorBundle(t *testing.T) {
+	dir := t.TempDir()
+
+	if err := os.MkdirAll(filepath.Join(dir, "out"), permDir); err != nil {
+		t.Fatalf("os.MkdirAll: %s", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "out/vendor.js"), []byte("vendor"), permFile); err != nil {
+		t.Fatalf("os.WriteFile: %s", err)
+	}
+	vendor := BundleResult{
+		Metafile: map[string]interface{}{
+			"outputs": map[string]interface{}{"out/vendor.js": map[string]interface{}{}},
+		},
+	}
+	if err := cacheVendorBundle(dir, "key", vendor); err != nil {
+		t.Fatalf("cacheVendorBundle: %s", err)
+	}
+
+	// Nothing is cached for other keys
+	_, cached, err := restoreVendorBundle(dir, "other")
+	if err != nil {
+		t.Fatalf("restoreVendorBundle: %s", err)
+	}
+	expect.DeepEqual(t, cached, false)
+
+	if err := os.RemoveAll(filepath.Join(dir, "out")); err != nil {
+		t.Fatalf("os.RemoveAll: %s", err)
+	}
+	restored, cached, err := restoreVendorBundle(dir, "key")
+	if err != nil {
+		t.Fatalf("restoreVendorBundle: %s", err)
+	}
+	expect.DeepEqual(t, cached, true)
+	expect.DeepEqual(t, restored, vendor)
+	byteStr, err := os.ReadFile(filepath.Join(dir, "out/vendor.js"))
+	if err != nil {
+		t.Fatalf("os.ReadFile: %s", err)
+	}
+	expect.DeepEqual(t, string(byteStr), "vendor")
+
+	// Partially evicted caches are cache misses
+	if err := os.Remove(filepath.Join(dir, vendorCacheDir, "key", "out/vendor.js")); err != nil {
+		t.Fatalf("os.Remove: %s", err)
+	}
+	_, cached, err = restoreVendorBundle(dir, "key")
+	if err != nil {
+		t.Fatalf("restoreVendorBundle: %s", err)
+	}
+	expect.DeepEqual(t, cached, false)
+}
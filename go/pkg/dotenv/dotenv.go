@@ -0,0 +1,189 @@
+package dotenv
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Describes a malformed line in a `.env` file
+type SyntaxError struct {
+	Filename string
+	Line     int
+	Text     string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s:%d: malformed line %q", e.Filename, e.Line, e.Text)
+}
+
+var keyRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Parses a `.env` file. lookup resolves interpolated variables and takes
+// precedence over variables defined in the file, e.g. `os.LookupEnv`.
+func Parse(r io.Reader, filename string, lookup func(string) (string, bool)) (map[string]string, error) {
+	vars := map[string]string{}
+	if err := parseInto(vars, r, filename, lookup); err != nil {
+		return nil, err
+	}
+	return vars, nil
+}
+
+// Parses a `.env` file into vars, overwriting existing keys. Existing keys can
+// be interpolated, which is how later files reference earlier files.
+func parseInto(vars map[string]string, r io.Reader, filename string, lookup func(string) (string, bool)) error {
+	resolve := func(key string) string {
+		if value, ok := lookup(key); ok {
+			return value
+		}
+		return vars[key]
+	}
+
+	scanner := bufio.NewScanner(r)
+	var lineNumber int
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		equalIndex := strings.Index(line, "=")
+		if equalIndex == -1 {
+			return &SyntaxError{Filename: filename, Line: lineNumber, Text: scanner.Text()}
+		}
+		key := strings.TrimSpace(line[:equalIndex])
+		if !keyRegex.MatchString(key) {
+			return &SyntaxError{Filename: filename, Line: lineNumber, Text: scanner.Text()}
+		}
+
+		value, err := parseValue(strings.TrimSpace(line[equalIndex+1:]), resolve)
+		if err != nil {
+			return &SyntaxError{Filename: filename, Line: lineNumber, Text: scanner.Text()}
+		}
+		vars[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("scanner.Err: %w", err)
+	}
+	return nil
+}
+
+// Parses the right-hand side of `KEY=value`. Single-quoted values are literal,
+// double-quoted values support escapes and interpolation, and unquoted values
+// support interpolation and trailing comments.
+func parseValue(raw string, resolve func(string) string) (string, error) {
+	if raw == "" {
+		return "", nil
+	}
+	switch raw[0] {
+	case '\'':
+		end := strings.Index(raw[1:], "'")
+		if end == -1 {
+			return "", fmt.Errorf("unterminated single quote")
+		}
+		return raw[1 : end+1], nil
+	case '"':
+		var unquoted strings.Builder
+		for index := 1; index < len(raw); index++ {
+			switch char := raw[index]; char {
+			case '\\':
+				if index+1 == len(raw) {
+					return "", fmt.Errorf("unterminated escape")
+				}
+				index++
+				switch raw[index] {
+				case 'n':
+					unquoted.WriteByte('\n')
+				case 't':
+					unquoted.WriteByte('\t')
+				default:
+					// Including `\$`, which escapes interpolation
+					unquoted.WriteByte(raw[index])
+				}
+			case '$':
+				// Interpolate in the same pass so escaped characters are never
+				// reinterpreted
+				match := variableRegex.FindString(raw[index:])
+				if match == "" {
+					unquoted.WriteByte(char)
+					continue
+				}
+				unquoted.WriteString(resolve(strings.Trim(match[1:], "{}")))
+				index += len(match) - 1
+			case '"':
+				return unquoted.String(), nil
+			default:
+				unquoted.WriteByte(char)
+			}
+		}
+		return "", fmt.Errorf("unterminated double quote")
+	}
+	if commentIndex := strings.Index(raw, " #"); commentIndex != -1 {
+		raw = strings.TrimSpace(raw[:commentIndex])
+	}
+	return interpolate(raw, resolve), nil
+}
+
+var (
+	interpolateRegex = regexp.MustCompile(`\\?\$(\{[A-Za-z_][A-Za-z0-9_]*\}|[A-Za-z_][A-Za-z0-9_]*)`)
+	variableRegex    = regexp.MustCompile(`^\$(\{[A-Za-z_][A-Za-z0-9_]*\}|[A-Za-z_][A-Za-z0-9_]*)`)
+)
+
+// Expands `$KEY` and `${KEY}`; `\$KEY` is kept verbatim as `$KEY`
+func interpolate(value string, resolve func(string) string) string {
+	return interpolateRegex.ReplaceAllStringFunc(value, func(match string) string {
+		if strings.HasPrefix(match, `\`) {
+			return match[1:]
+		}
+		key := strings.Trim(match[1:], "{}")
+		return resolve(key)
+	})
+}
+
+// Reads `.env` files in ascending order of precedence; missing files are
+// skipped. lookup resolves interpolated variables, e.g. `os.LookupEnv`, and
+// variables it resolves are omitted so they're never overwritten.
+func Read(lookup func(string) (string, bool), filenames ...string) (map[string]string, error) {
+	merged := map[string]string{}
+	for _, filename := range filenames {
+		file, err := os.Open(filename)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("os.Open: %w", err)
+		}
+		err = parseInto(merged, file, filename, lookup)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	for key := range merged {
+		if _, ok := lookup(key); ok {
+			delete(merged, key)
+		}
+	}
+	return merged, nil
+}
+
+// Loads `.env` files into the environment in ascending order of precedence;
+// missing files are skipped. Variables that are already set in the environment
+// are never overwritten.
+func Load(filenames ...string) error {
+	vars, err := Read(os.LookupEnv, filenames...)
+	if err != nil {
+		return err
+	}
+	for key, value := range vars {
+		if err := os.Setenv(key, value); err != nil {
+			return fmt.Errorf("os.Setenv: %w", err)
+		}
+	}
+	return nil
+}
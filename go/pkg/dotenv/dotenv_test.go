@@ -0,0 +1,117 @@
+package dotenv
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/zaydek/go-ipc-test/go/pkg/expect"
+)
+
+func lookupNone(string) (string, bool) { return "", false }
+
+func TestParse(t *testing.T) {
+	const env = `
+		# Comment
+		FOO=foo
+		export BAR = bar # Trailing comment
+		SINGLE='$FOO # literal'
+		DOUBLE="line\nbreak ${FOO}"
+		INTERPOLATED=$FOO-${BAR}
+		ESCAPED=\$FOO
+		DOUBLE_ESCAPED="\$FOO \\$FOO $ ${"
+		EMPTY=
+	`
+
+	vars, err := Parse(strings.NewReader(env), ".env", lookupNone)
+	if err != nil {
+		t.Fatalf("Parse: %s", err)
+	}
+	expect.DeepEqual(t, vars, map[string]string{
+		"FOO":          "foo",
+		"BAR":          "bar",
+		"SINGLE":       "$FOO # literal",
+		"DOUBLE":       "line\nbreak foo",
+		"INTERPOLATED": "foo-bar",
+		"ESCAPED":      "$FOO",
+		// An escaped backslash doesn't escape the `$` that follows it
+		"DOUBLE_ESCAPED": `$FOO \foo $ ${`,
+		"EMPTY":          "",
+	})
+}
+
+func TestParseLookupPrecedence(t *testing.T) {
+	const env = `
+		FOO=foo
+		BAR=$FOO
+	`
+
+	vars, err := Parse(strings.NewReader(env), ".env", func(key string) (string, bool) {
+		if key == "FOO" {
+			return "os", true
+		}
+		return "", false
+	})
+	if err != nil {
+		t.Fatalf("Parse: %s", err)
+	}
+	expect.DeepEqual(t, vars["BAR"], "os")
+}
+
+func TestParseSyntaxError(t *testing.T) {
+	_, err := Parse(strings.NewReader("FOO=foo\nBAR\n"), ".env", lookupNone)
+	syntaxErr, ok := err.(*SyntaxError)
+	if !ok {
+		t.Fatalf("Parse: got %v want *SyntaxError", err)
+	}
+	expect.DeepEqual(t, syntaxErr.Line, 2)
+	expect.DeepEqual(t, err.Error(), `.env:2: malformed line "BAR"`)
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	write := func(filename, contents string) string {
+		path := dir + "/" + filename
+		if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatalf("os.WriteFile: %s", err)
+		}
+		return path
+	}
+
+	t.Setenv("DOTENV_TEST_OS", "os")
+	env := write(".env", "DOTENV_TEST_A=env\nDOTENV_TEST_B=env\nDOTENV_TEST_OS=env\n")
+	envLocal := write(".env.local", "DOTENV_TEST_B=local\nDOTENV_TEST_C=${DOTENV_TEST_A}-local\n")
+
+	if err := Load(env, envLocal, dir+"/.env.missing"); err != nil {
+		t.Fatalf("Load: %s", err)
+	}
+	defer func() {
+		os.Unsetenv("DOTENV_TEST_A")
+		os.Unsetenv("DOTENV_TEST_B")
+		os.Unsetenv("DOTENV_TEST_C")
+	}()
+
+	expect.DeepEqual(t, os.Getenv("DOTENV_TEST_A"), "env")
+	expect.DeepEqual(t, os.Getenv("DOTENV_TEST_B"), "local")
+	expect.DeepEqual(t, os.Getenv("DOTENV_TEST_C"), "env-local")
+	expect.DeepEqual(t, os.Getenv("DOTENV_TEST_OS"), "os")
+}
+
+func TestRead(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(dir+"/.env", []byte("A=a\nB=${HOST}-b\nHOST=env\n"), 0644); err != nil {
+		t.Fatalf("os.WriteFile: %s", err)
+	}
+
+	lookup := func(key string) (string, bool) {
+		if key == "HOST" {
+			return "host", true
+		}
+		return "", false
+	}
+	vars, err := Read(lookup, dir+"/.env", dir+"/.env.missing")
+	if err != nil {
+		t.Fatalf("Read: %s", err)
+	}
+	expect.DeepEqual(t, vars, map[string]string{"A": "a", "B": "host-b"})
+}
@@ -0,0 +1,233 @@
+package ipc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Environment variables that offer compression to a process; see
+// OfferCompression
+const (
+	CompressionEnv          = "IPC_COMPRESSION"           // "gzip" when the host accepts compressed messages
+	CompressionThresholdEnv = "IPC_COMPRESSION_THRESHOLD" // Messages over this many bytes should be compressed
+)
+
+// Prefixes compressed messages, which are base64-encoded gzip. The record
+// separator never appears in JSON or, in practice, in logs.
+const compressedPrefix = "\x1egzip:"
+
+// Returns the environment variables that tell a process the host accepts
+// messages over threshold bytes compressed, e.g. for WithEnv
+func OfferCompression(threshold int) []string {
+	return []string{CompressionEnv + "=gzip", CompressionThresholdEnv + "=" + strconv.Itoa(threshold)}
+}
+
+// Reports whether line is a compressed message
+func IsCompressed(line string) bool {
+	return strings.HasPrefix(line, compressedPrefix)
+}
+
+// Compresses line when it's over threshold bytes; otherwise returns line
+func CompressLine(line string, threshold int) (string, error) {
+	if len(line) <= threshold {
+		return line, nil
+	}
+	var buf bytes.Buffer
+	buf.WriteString(compressedPrefix)
+	encoder := base64.NewEncoder(base64.StdEncoding, &buf)
+	// Favor throughput; messages are compressed once and read once
+	writer, err := gzip.NewWriterLevel(encoder, gzip.BestSpeed)
+	if err != nil {
+		return "", fmt.Errorf("gzip.NewWriterLevel: %w", err)
+	}
+	if _, err := io.WriteString(writer, line); err != nil {
+		return "", fmt.Errorf("io.WriteString: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return "", fmt.Errorf("writer.Close: %w", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return "", fmt.Errorf("encoder.Close: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// Decompresses line when it's compressed; otherwise returns line. Messages
+// that decompress to over maxSize bytes fail with *ReadError so compressed
+// messages can't get around WithMaxMessageSize; zero means no limit.
+func DecompressLine(line string, maxSize int) (string, error) {
+	if !IsCompressed(line) {
+		return line, nil
+	}
+	decoder := base64.NewDecoder(base64.StdEncoding, strings.NewReader(line[len(compressedPrefix):]))
+	gzipReader, err := gzip.NewReader(decoder)
+	if err != nil {
+		return "", fmt.Errorf("gzip.NewReader: %w", err)
+	}
+	var reader io.Reader = gzipReader
+	if maxSize > 0 {
+		// Stop inflating one byte past the limit
+		reader = io.LimitReader(gzipReader, int64(maxSize)+1)
+	}
+	var str strings.Builder
+	if _, err := io.Copy(&str, reader); err != nil {
+		return "", fmt.Errorf("io.Copy: %w", err)
+	}
+	if maxSize > 0 && str.Len() > maxSize {
+		return "", &ReadError{Stream: StreamStdout, Err: ErrMessageTooLarge}
+	}
+	return str.String(), nil
+}
+
+// Compresses and decompresses messages crossing a transport, transparently to
+// callers. Compressed stdout messages are always decompressed; stdin messages
+// are only compressed once Enable is called because the process must agree
+// to decompress them.
+//
+// Compression is negotiated like so: the host starts the process with
+// OfferCompression, which lets the process compress stdout messages, and the
+// process tells the host it can decompress stdin messages, e.g. in a hello
+// message, after which the host calls Enable.
+//
+// A stdout message that can't be decompressed closes stdout, like a read error
+// from Process; see Err.
+type Compressor struct {
+	transport Transport
+	threshold int
+	maxSize   int
+	enabled   int32
+
+	stdin  chan string
+	stdout chan string
+	stderr chan string
+	exited chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+// Compresses stdin messages over threshold bytes once enabled. Decompressed
+// stdout messages over maxSize bytes close stdout; zero means no limit.
+func NewCompressor(t Transport, threshold, maxSize int) *Compressor {
+	c := &Compressor{
+		transport: t,
+		threshold: threshold,
+		maxSize:   maxSize,
+		stdin:     make(chan string),
+		stdout:    make(chan string),
+		stderr:    make(chan string),
+		exited:    make(chan struct{}),
+	}
+
+	go func() {
+		defer close(t.Stdin())
+		for message := range c.stdin {
+			if atomic.LoadInt32(&c.enabled) == 1 {
+				compressed, err := CompressLine(message, c.threshold)
+				if err != nil {
+					c.fail(fmt.Errorf("CompressLine: %w", err))
+				} else {
+					message = compressed
+				}
+			}
+			t.Stdin() <- message
+		}
+	}()
+
+	stdoutDone := make(chan struct{})
+	go func() {
+		defer close(stdoutDone)
+		for line := range t.Stdout() {
+			decompressed, err := DecompressLine(line, c.maxSize)
+			if err != nil {
+				c.fail(fmt.Errorf("DecompressLine: %w", err))
+				break
+			}
+			c.stdout <- decompressed
+		}
+		close(c.stdout)
+		// Discard the rest so the transport isn't blocked until it's killed
+		for range t.Stdout() {
+		}
+	}()
+
+	stderrDone := make(chan struct{})
+	go func() {
+		defer close(stderrDone)
+		defer close(c.stderr)
+		// Send stderr after stdout, like Process
+		<-stdoutDone
+		for text := range t.Stderr() {
+			c.stderr <- text
+		}
+	}()
+
+	go func() {
+		<-stderrDone
+		<-t.Exited()
+		close(c.exited)
+	}()
+	return c
+}
+
+// Starts compressing stdin messages over the threshold
+func (c *Compressor) Enable() {
+	atomic.StoreInt32(&c.enabled, 1)
+}
+
+func (c *Compressor) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		c.err = err
+	}
+}
+
+// Returns the first error compressing or decompressing a message, or else the
+// transport's error, if any, e.g. *ReadError from Process or DecompressLine
+func (c *Compressor) Err() error {
+	c.mu.Lock()
+	err := c.err
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if transport, ok := c.transport.(interface{ Err() error }); ok {
+		return transport.Err()
+	}
+	return nil
+}
+
+func (c *Compressor) Stdin() chan<- string {
+	return c.stdin
+}
+
+func (c *Compressor) Stdout() <-chan string {
+	return c.stdout
+}
+
+func (c *Compressor) Stderr() <-chan string {
+	return c.stderr
+}
+
+func (c *Compressor) Kill() error {
+	return c.transport.Kill()
+}
+
+func (c *Compressor) Exited() <-chan struct{} {
+	return c.exited
+}
+
+func (c *Compressor) Wait() error {
+	<-c.exited
+	return c.transport.Wait()
+}
+
+var _ Transport = (*Compressor)(nil)
@@ -0,0 +1,198 @@
+package ipc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/zaydek/go-ipc-test/go/pkg/expect"
+)
+
+func TestCompressLine(t *testing.T) {
+	small, err := CompressLine("foo", 16)
+	if err != nil {
+		t.Fatalf("CompressLine: %s", err)
+	}
+	expect.DeepEqual(t, small, "foo")
+
+	line := strings.Repeat("foo", 100)
+	large, err := CompressLine(line, 16)
+	if err != nil {
+		t.Fatalf("CompressLine: %s", err)
+	}
+	expect.DeepEqual(t, IsCompressed(large), true)
+	expect.DeepEqual(t, len(large) < len(line), true)
+
+	decompressed, err := DecompressLine(large, len(line))
+	if err != nil {
+		t.Fatalf("DecompressLine: %s", err)
+	}
+	expect.DeepEqual(t, decompressed, line)
+
+	_, err = DecompressLine(compressedPrefix+"foo", 0)
+	expect.DeepEqual(t, err != nil, true)
+}
+
+func TestDecompressLineTooLarge(t *testing.T) {
+	// Compresses to well under the limit but inflates to over it
+	line := strings.Repeat("a", 1024*1024)
+	compressed, err := CompressLine(line, 0)
+	if err != nil {
+		t.Fatalf("CompressLine: %s", err)
+	}
+	expect.DeepEqual(t, len(compressed) < 64*1024, true)
+
+	_, err = DecompressLine(compressed, 64*1024)
+	var readErr *ReadError
+	expect.DeepEqual(t, errors.As(err, &readErr), true)
+	expect.DeepEqual(t, readErr.Stream, StreamStdout)
+	expect.DeepEqual(t, errors.Is(err, ErrMessageTooLarge), true)
+}
+
+func TestCompressorCorrupt(t *testing.T) {
+	process, err := StartWithOptions([]string{"sh", "-c", `printf '\036gzip:foo\nbar\n'`})
+	if err != nil {
+		t.Fatalf("StartWithOptions: %s", err)
+	}
+	compressor := NewCompressor(process, 64, 0)
+
+	// Corrupt messages close stdout rather than being dropped
+	var lines []string
+	for line := range compressor.Stdout() {
+		lines = append(lines, line)
+	}
+	expect.DeepEqual(t, lines, []string(nil))
+	expect.DeepEqual(t, strings.HasPrefix(compressor.Err().Error(), "DecompressLine: "), true)
+
+	close(compressor.Stdin())
+	for range compressor.Stderr() {
+	}
+	if err := compressor.Wait(); err != nil {
+		t.Fatalf("Wait: %s", err)
+	}
+}
+
+// Echoes lines, decompressing what it reads and compressing what it writes
+// like the backend
+const compressionTestScript = `
+	const nodeReadline = require("readline")
+	const zlib = require("zlib")
+
+	const prefix = "\x1egzip:"
+	const threshold = Number(process.env.IPC_COMPRESSION_THRESHOLD)
+
+	nodeReadline.createInterface({ input: process.stdin }).on("line", line => {
+		const compressed = line.startsWith(prefix)
+		if (compressed) {
+			line = zlib.gunzipSync(Buffer.from(line.slice(prefix.length), "base64")).toString()
+		}
+		line = JSON.stringify({ compressed, line })
+		if (process.env.IPC_COMPRESSION === "gzip" && line.length > threshold) {
+			line = prefix + zlib.gzipSync(line, { level: 1 }).toString("base64")
+		}
+		console.log(line)
+	})
+`
+
+func TestCompressor(t *testing.T) {
+	if err := os.WriteFile("compression_test.go.script.js", []byte(compressionTestScript), 0644); err != nil {
+		t.Fatalf("os.WriteFile: %s", err)
+	}
+	defer os.Remove("compression_test.go.script.js")
+
+	process, err := StartWithOptions([]string{"node", "compression_test.go.script.js"},
+		WithEnv(OfferCompression(64)...))
+	if err != nil {
+		t.Fatalf("StartWithOptions: %s", err)
+	}
+	compressor := NewCompressor(process, 64, 0)
+
+	type echo struct {
+		Compressed bool   `json:"compressed"`
+		Line       string `json:"line"`
+	}
+	roundTrip := func(line string) echo {
+		compressor.Stdin() <- line
+		var e echo
+		if err := json.Unmarshal([]byte(<-compressor.Stdout()), &e); err != nil {
+			t.Fatalf("json.Unmarshal: %s", err)
+		}
+		return e
+	}
+
+	large := strings.Repeat("foo", 100)
+
+	// stdin messages aren't compressed until enabled, but stdout messages are
+	// always decompressed
+	expect.DeepEqual(t, roundTrip(large), echo{Compressed: false, Line: large})
+	compressor.Enable()
+	expect.DeepEqual(t, roundTrip(large), echo{Compressed: true, Line: large})
+	expect.DeepEqual(t, roundTrip("foo"), echo{Compressed: false, Line: "foo"})
+
+	close(compressor.Stdin())
+	for range compressor.Stdout() {
+	}
+	for range compressor.Stderr() {
+	}
+	if err := compressor.Wait(); err != nil {
+		t.Fatalf("Wait: %s", err)
+	}
+	expect.DeepEqual(t, compressor.Err(), nil)
+}
+
+// Sends JSON messages about the size of a large metafile through cat and back,
+// with and without compression. wire-B/op is how many bytes cross the pipe each
+// way.
+func BenchmarkCompression(b *testing.B) {
+	var inputs []map[string]interface{}
+	for index := 0; index < 10000; index++ {
+		inputs = append(inputs, map[string]interface{}{
+			"path":    fmt.Sprintf("node_modules/package-%d/dist/index.js", index),
+			"bytes":   index * 31,
+			"imports": []string{"react", "react-dom"},
+		})
+	}
+	byteStr, err := json.Marshal(map[string]interface{}{"inputs": inputs})
+	if err != nil {
+		b.Fatalf("json.Marshal: %s", err)
+	}
+	message := string(byteStr)
+
+	run := func(b *testing.B, compress bool) {
+		process, err := StartWithOptions([]string{"cat"}, WithMaxMessageSize(64*1024*1024))
+		if err != nil {
+			b.Fatalf("StartWithOptions: %s", err)
+		}
+		var transport Transport = process
+		wire := message
+		if compress {
+			compressor := NewCompressor(process, 64*1024, 0)
+			compressor.Enable()
+			transport = compressor
+			if wire, err = CompressLine(message, 64*1024); err != nil {
+				b.Fatalf("CompressLine: %s", err)
+			}
+		}
+		b.SetBytes(int64(len(message)))
+		b.ResetTimer()
+		for index := 0; index < b.N; index++ {
+			transport.Stdin() <- message
+			if line := <-transport.Stdout(); len(line) != len(message) {
+				b.Fatalf("got %d bytes; want %d", len(line), len(message))
+			}
+		}
+		b.StopTimer()
+		b.ReportMetric(float64(len(wire)), "wire-B/op")
+		close(transport.Stdin())
+		for range transport.Stdout() {
+		}
+		for range transport.Stderr() {
+		}
+		transport.Wait()
+	}
+	b.Run("plain", func(b *testing.B) { run(b, false) })
+	b.Run("gzip", func(b *testing.B) { run(b, true) })
+}
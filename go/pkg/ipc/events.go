@@ -0,0 +1,110 @@
+package ipc
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// The type of an Event
+type EventType string
+
+const (
+	EventStdout  EventType = "stdout"  // A stdout line
+	EventStderr  EventType = "stderr"  // A chunk of stderr, as it was read
+	EventMessage EventType = "message" // A stdout line that's a message; see WithEventDecoder
+	EventExit    EventType = "exit"    // The process exited; always the last event
+	EventError   EventType = "error"   // A stream failed, e.g. *ReadError
+)
+
+// Describes something that happened to a process started with WithEvents.
+// Events are sent in the order they happened, as far as the host can tell;
+// stdout and stderr are separate pipes so output written at the same time can
+// be read in either order.
+type Event struct {
+	Type EventType
+	Time time.Time
+	Seq  uint64 // Starts at 1 and increases by 1 per event, including dropped ones
+
+	Text     string      // The line or chunk for EventStdout, EventStderr, and EventMessage
+	Messages Messages    // The decoded JSON-RPC messages for EventMessage, if any
+	Message  interface{} // The message decoded by WithEventDecoder for EventMessage, if any
+	Err      error       // The error for EventError and the result of Wait for EventExit
+}
+
+// The number of events buffered for a slow consumer before output events are
+// dropped
+const eventBufferSize = 1024
+
+// Returns the process's events when it was started with WithEvents; otherwise
+// returns nil. Closed after EventExit.
+func (p *Process) Events() <-chan Event {
+	return p.events
+}
+
+// Returns the number of output events dropped because the consumer fell
+// behind; dropped events leave gaps in Seq
+func (p *Process) DroppedEvents() int64 {
+	if p.droppedEvents == nil {
+		return 0
+	}
+	return atomic.LoadInt64(p.droppedEvents)
+}
+
+// Stamps and sends an event. Events are stamped and sent one at a time so
+// sequence numbers are in the order events are received. Output events are
+// dropped rather than block reading the process when the buffer is full;
+// EventError and EventExit are always sent.
+func (p *Process) emit(e Event) {
+	p.eventsMu.Lock()
+	defer p.eventsMu.Unlock()
+	p.seq++
+	e.Seq = p.seq
+	e.Time = time.Now()
+	if e.Type == EventError || e.Type == EventExit {
+		p.events <- e
+		return
+	}
+	select {
+	case p.events <- e:
+	default:
+		atomic.AddInt64(p.droppedEvents, 1)
+	}
+}
+
+// Emits a stdout line, decoding messages with the decoder from
+// WithEventDecoder, if any, and then JSON-RPC messages
+func (p *Process) emitStdout(line string) {
+	if p.eventDecoder != nil {
+		if message, ok := p.eventDecoder([]byte(line)); ok {
+			p.emit(Event{Type: EventMessage, Text: line, Message: message})
+			return
+		}
+	}
+	if IsMessage([]byte(line)) {
+		if messages, err := DecodeMessages([]byte(line)); err == nil {
+			p.emit(Event{Type: EventMessage, Text: line, Messages: messages})
+			return
+		}
+	}
+	p.emit(Event{Type: EventStdout, Text: line})
+}
+
+// Emits stderr as it's read until EOF
+func (p *Process) emitStderr(reader io.Reader) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := reader.Read(buf)
+		if n > 0 {
+			p.emit(Event{Type: EventStderr, Text: string(buf[:n])})
+		}
+		if err == io.EOF {
+			return
+		} else if err != nil {
+			readErr := &ReadError{Stream: StreamStderr, Err: err}
+			p.fail(readErr)
+			p.emit(Event{Type: EventError, Err: readErr})
+			return
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package ipc
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/zaydek/go-ipc-test/go/pkg/expect"
+)
+
+func TestWithEvents(t *testing.T) {
+	process, err := StartWithOptions([]string{"sh", "-c", `
+		echo foo
+		sleep 0.05
+		echo bar >&2
+		sleep 0.05
+		echo '{"jsonrpc":"2.0","method":"ping"}'
+		exit 3
+	`}, WithEvents())
+	if err != nil {
+		t.Fatalf("StartWithOptions: %s", err)
+	}
+	_, ok := <-process.Stdout()
+	expect.DeepEqual(t, ok, false)
+
+	var events []Event
+	for event := range process.Events() {
+		events = append(events, event)
+	}
+	var types []EventType
+	for index, event := range events {
+		types = append(types, event.Type)
+		expect.DeepEqual(t, event.Seq, uint64(index+1))
+	}
+	expect.DeepEqual(t, types, []EventType{EventStdout, EventStderr, EventMessage, EventExit})
+	expect.DeepEqual(t, events[0].Text, "foo")
+	expect.DeepEqual(t, events[1].Text, "bar\n")
+	expect.DeepEqual(t, events[2].Messages.Requests[0].Method, "ping")
+
+	var exitErr *ExitError
+	if !errors.As(events[3].Err, &exitErr) {
+		t.Fatalf("EventExit: got %v want *ExitError", events[3].Err)
+	}
+	expect.DeepEqual(t, exitErr.Code, 3)
+	expect.DeepEqual(t, process.Wait(), events[3].Err)
+}
+
+func TestWithEventDecoder(t *testing.T) {
+	type envelope struct {
+		Kind string
+	}
+	decode := func(line []byte) (interface{}, bool) {
+		var e envelope
+		if err := json.Unmarshal(line, &e); err != nil || e.Kind == "" {
+			return nil, false
+		}
+		return e, true
+	}
+	process, err := StartWithOptions([]string{"sh", "-c", `
+		echo '{"Kind":"build_done"}'
+		echo '{"jsonrpc":"2.0","method":"ping"}'
+		echo foo
+	`}, WithEvents(), WithEventDecoder(decode))
+	if err != nil {
+		t.Fatalf("StartWithOptions: %s", err)
+	}
+
+	var events []Event
+	for event := range process.Events() {
+		events = append(events, event)
+	}
+	expect.DeepEqual(t, len(events), 4)
+	expect.DeepEqual(t, events[0].Type, EventMessage)
+	expect.DeepEqual(t, events[0].Message, interface{}(envelope{Kind: "build_done"}))
+	expect.DeepEqual(t, events[1].Type, EventMessage)
+	expect.DeepEqual(t, events[1].Messages.Requests[0].Method, "ping")
+	expect.DeepEqual(t, events[2].Type, EventStdout)
+	expect.DeepEqual(t, events[3].Type, EventExit)
+}
+
+func TestWithEventsSlowConsumer(t *testing.T) {
+	process, err := StartWithOptions([]string{"sh", "-c", `
+		index=0
+		while [ $index -lt 2000 ]; do
+			echo $index
+			index=$((index + 1))
+		done
+	`}, WithEvents())
+	if err != nil {
+		t.Fatalf("StartWithOptions: %s", err)
+	}
+
+	// Reading stdout doesn't wait for the consumer; events past the buffer
+	// are dropped
+	deadline := time.Now().Add(5 * time.Second)
+	for process.DroppedEvents() < 2000-eventBufferSize && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	expect.DeepEqual(t, process.DroppedEvents(), int64(2000-eventBufferSize))
+
+	var events []Event
+	for event := range process.Events() {
+		events = append(events, event)
+	}
+	expect.DeepEqual(t, len(events), eventBufferSize+1)
+	expect.DeepEqual(t, events[len(events)-1].Type, EventExit)
+	expect.DeepEqual(t, events[len(events)-1].Seq, uint64(2001))
+}
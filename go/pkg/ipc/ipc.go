@@ -2,87 +2,373 @@ package ipc
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
+	"io"
 	"os/exec"
 	"strings"
+	"sync"
+	"syscall"
 )
 
-// Starts a long-lived IPC process. stdout messages are read line-by-line
+// Describes a long-lived IPC process or a fake of one, e.g. for tests. See
+// package ipctest.
+type Transport interface {
+	Stdin() chan<- string
+	Stdout() <-chan string // Closed when the process exits
+	Stderr() <-chan string // Closed after Stdout
+	Kill() error
+	Exited() <-chan struct{}
+	Wait() error
+}
+
+// Describes a long-lived IPC process. stdout messages are read line-by-line
 // whereas stderr messages are read once.
-func NewCommand(commandArgs ...string) (stdin, stdout, stderr chan string, err error) {
-	cmd := exec.Command(commandArgs[0], commandArgs[1:]...)
+type Process struct {
+	// Bidirectional for NewCommand
+	stdin  chan string
+	stdout chan string
+	stderr chan string
 
-	// Get pipes
-	stdinPipe, err := cmd.StdinPipe()
-	if err != nil {
-		returnError := fmt.Errorf("cmd.StdinPipe: %w", err)
-		return nil, nil, nil, returnError
+	cmd     *exec.Cmd
+	exited  chan struct{}
+	waitErr error
+
+	dropped map[Stream]*int64
+	pumping sync.WaitGroup // Buffered stdout and stderr
+
+	mu  sync.Mutex
+	err error
+
+	// See WithEvents
+	events        chan Event
+	eventDecoder  func(line []byte) (interface{}, bool)
+	eventsMu      sync.Mutex
+	seq           uint64
+	droppedEvents *int64
+}
+
+// Starts a long-lived IPC process
+func Start(commandArgs ...string) (*Process, error) {
+	return StartWithOptions(commandArgs)
+}
+
+// Starts a long-lived IPC process configured by options, e.g. WithBuffer
+func StartWithOptions(commandArgs []string, options ...Option) (*Process, error) {
+	return startCmd(exec.Command(commandArgs[0], commandArgs[1:]...), newConfig(options))
+}
+
+func startCmd(cmd *exec.Cmd, c config) (*Process, error) {
+	c.apply(cmd)
+	setProcessGroup(cmd)
+	if err := limitCommand(cmd, c.limits); err != nil {
+		returnError := fmt.Errorf("limitCommand: %w", err)
+		return nil, returnError
 	}
 
-	stdoutPipe, err := cmd.StdoutPipe()
-	if err != nil {
-		returnError := fmt.Errorf("cmd.StdoutPipe: %w", err)
-		return nil, nil, nil, returnError
+	// Get pipes, or a pseudo-terminal for stdin and stdout; see WithPTY
+	var stdinPipe io.WriteCloser
+	var stdoutPipe io.Reader
+	var terminal *pty
+	var err error
+	if c.pty {
+		if terminal, err = openPTY(cmd); err != nil {
+			returnError := fmt.Errorf("openPTY: %w", err)
+			return nil, returnError
+		}
+		stdinPipe, stdoutPipe = terminal, terminal
+	} else {
+		if stdinPipe, err = cmd.StdinPipe(); err != nil {
+			returnError := fmt.Errorf("cmd.StdinPipe: %w", err)
+			return nil, returnError
+		}
+		if stdoutPipe, err = cmd.StdoutPipe(); err != nil {
+			returnError := fmt.Errorf("cmd.StdoutPipe: %w", err)
+			return nil, returnError
+		}
 	}
 
 	stderrPipe, err := cmd.StderrPipe()
 	if err != nil {
+		if terminal != nil {
+			terminal.close()
+		}
 		returnError := fmt.Errorf("cmd.StderrPipe: %w", err)
-		return nil, nil, nil, returnError
+		return nil, returnError
 	}
 
 	// Start the command
 	if err := cmd.Start(); err != nil {
+		if terminal != nil {
+			terminal.close()
+		}
 		returnError := fmt.Errorf("cmd.Start: %w", err)
-		return nil, nil, nil, returnError
+		return nil, returnError
+	}
+	if terminal != nil {
+		terminal.started()
+	}
+
+	process := &Process{
+		stdout: make(chan string),
+		stderr: make(chan string),
+		cmd:    cmd,
+		exited: make(chan struct{}),
+		dropped: map[Stream]*int64{
+			StreamStdin:  new(int64),
+			StreamStdout: new(int64),
+			StreamStderr: new(int64),
+		},
 	}
 
-	stdin = make(chan string)
+	// Buffered streams are pumped; see WithBuffer
+	stdinLines := make(chan string)
+	process.stdin, _ = process.connect(StreamStdin, c.buffers[StreamStdin], stdinLines)
+	var stdout, stderr chan string
+	var stdoutDrained <-chan struct{}
+	if c.events {
+		// Output is sent to Events instead
+		process.events = make(chan Event, eventBufferSize)
+		process.eventDecoder = c.eventDecoder
+		process.droppedEvents = new(int64)
+		close(process.stdout)
+		close(process.stderr)
+	} else {
+		stdout, stdoutDrained = process.connect(StreamStdout, c.buffers[StreamStdout], process.stdout)
+		stderr, _ = process.connect(StreamStderr, c.buffers[StreamStderr], process.stderr)
+	}
+
+	// Reads must complete before cmd.Wait
+	var readers sync.WaitGroup
+	readers.Add(2)
+
 	go func() {
-		defer func() {
-			stdinPipe.Close()
-			close(stdin)
-		}()
-		for message := range stdin {
+		defer stdinPipe.Close()
+		stdinPipe.Write(c.stdinBytes)
+		for message := range stdinLines {
+			// Writes fail once the process exits; keep draining so senders
+			// never block
 			fmt.Fprintln(stdinPipe, message)
 		}
 	}()
 
-	stdout = make(chan string)
+	stdoutDone := make(chan struct{})
 	go func() {
-		defer func() {
-			stdoutPipe.Close()
+		defer readers.Done()
+		// Read line-by-line
+		reader := bufio.NewReader(stdoutPipe)
+		send := func(line string) {
+			stdout <- line
+		}
+		if c.events {
+			send = process.emitStdout
+		}
+		err := readMessages(reader, StreamStdout, c.maxMessageSize, send)
+		if !c.events {
 			close(stdout)
-		}()
-		// Scan line-by-line
-		scanner := bufio.NewScanner(stdoutPipe)
-		scanner.Buffer(make([]byte, 1024*1024), 1024*1024)
-		for scanner.Scan() {
-			if line := scanner.Text(); line != "" {
-				stdout <- line
+		}
+		close(stdoutDone)
+		if err != nil {
+			process.fail(err)
+			if c.events {
+				process.emit(Event{Type: EventError, Err: err})
 			}
+			// Keep draining so the process never blocks on a full pipe
+			io.Copy(io.Discard, reader)
 		}
 	}()
 
-	stderr = make(chan string)
-	go func() {
-		defer func() {
-			stderrPipe.Close()
-			close(stderr)
+	if c.events {
+		go func() {
+			defer readers.Done()
+			process.emitStderr(stderrPipe)
+		}()
+	} else {
+		go func() {
+			defer func() {
+				// Close stderr after stdout is drained so a clean exit never
+				// races the last stdout lines, including buffered ones
+				<-stdoutDone
+				if stdoutDrained != nil {
+					<-stdoutDrained
+				}
+				close(stderr)
+				readers.Done()
+			}()
+			// Scan once
+			scanner := bufio.NewScanner(stderrPipe)
+			scanner.Split(func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+				return len(data), data, nil
+			})
+			scanner.Scan()
+			if text := scanner.Text(); text != "" {
+				stderr <- strings.TrimRight(
+					text,
+					"\n", // Remove the EOF
+				)
+			}
+			if err := scanner.Err(); err != nil {
+				process.fail(&ReadError{Stream: StreamStderr, Err: err})
+			}
 		}()
-		// Scan once
-		scanner := bufio.NewScanner(stderrPipe)
-		scanner.Split(func(data []byte, atEOF bool) (advance int, token []byte, err error) {
-			return len(data), data, nil
-		})
-		scanner.Scan()
-		if text := scanner.Text(); text != "" {
-			stderr <- strings.TrimRight(
-				text,
-				"\n", // Remove the EOF
-			)
+	}
+
+	go func() {
+		readers.Wait()
+		process.pumping.Wait()
+		process.waitErr = newExitError(cmd.Wait(), c)
+		if terminal != nil {
+			terminal.close()
+		}
+		if c.events {
+			process.emit(Event{Type: EventExit, Err: process.waitErr})
+			close(process.events)
 		}
+		close(process.exited)
 	}()
+	return process, nil
+}
+
+// Describes why a process exited unsuccessfully; returned by Wait
+type ExitError struct {
+	Code   int            // The exit code, or -1 when the process was killed by a signal
+	Signal syscall.Signal // The signal that killed the process, if any
+	Limit  Limit          // The limit the process likely exceeded, if any
+	Err    *exec.ExitError
+}
+
+func (e *ExitError) Error() string {
+	if e.Limit != "" {
+		return fmt.Sprintf("ipc: %s (%s limit exceeded)", e.Err, e.Limit)
+	}
+	return fmt.Sprintf("ipc: %s", e.Err)
+}
 
-	return stdin, stdout, stderr, nil
+func (e *ExitError) Unwrap() error {
+	return e.Err
 }
+
+// Wraps *exec.ExitError in *ExitError; other errors are returned as is
+func newExitError(err error, c config) error {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return err
+	}
+	e := &ExitError{Code: exitErr.ExitCode(), Err: exitErr}
+	if status, ok := exitErr.Sys().(interface {
+		Signaled() bool
+		Signal() syscall.Signal
+	}); ok && status.Signaled() {
+		e.Signal = status.Signal()
+		e.Limit = exceededLimit(e.Signal, c)
+	}
+	return e
+}
+
+// Wrapped by *ReadError when a message exceeds the maximum size; see
+// WithMaxMessageSize
+var ErrMessageTooLarge = errors.New("message too large")
+
+// Describes an error reading a stream. The stream is closed after the error.
+type ReadError struct {
+	Stream Stream
+	Size   int // The size of the offending message in bytes, if any
+	Err    error
+}
+
+func (e *ReadError) Error() string {
+	if e.Size > 0 {
+		return fmt.Sprintf("ipc: reading %s: %d-byte message: %s", e.Stream, e.Size, e.Err)
+	}
+	return fmt.Sprintf("ipc: reading %s: %s", e.Stream, e.Err)
+}
+
+func (e *ReadError) Unwrap() error {
+	return e.Err
+}
+
+// Reads newline-delimited messages from reader until EOF. Empty messages are
+// skipped. Messages over maxSize bytes fail with *ReadError; zero means no
+// limit.
+func readMessages(reader *bufio.Reader, stream Stream, maxSize int, send func(message string)) error {
+	for {
+		var message []byte
+		var size int
+		var err error
+		for {
+			var chunk []byte
+			chunk, err = reader.ReadSlice('\n')
+			size += len(chunk)
+			// Stop buffering oversized messages but keep counting
+			if maxSize == 0 || size <= maxSize+1 {
+				message = append(message, chunk...)
+			}
+			if err != bufio.ErrBufferFull {
+				break
+			}
+		}
+		if err == nil {
+			size-- // Don't count the newline
+		}
+		if maxSize > 0 && size > maxSize {
+			return &ReadError{Stream: stream, Size: size, Err: ErrMessageTooLarge}
+		}
+		if line := strings.TrimSuffix(strings.TrimSuffix(string(message), "\n"), "\r"); line != "" {
+			send(line)
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return &ReadError{Stream: stream, Err: err}
+		}
+	}
+}
+
+// Starts a long-lived IPC process. stdout messages are read line-by-line
+// whereas stderr messages are read once.
+func NewCommand(commandArgs ...string) (stdin, stdout, stderr chan string, err error) {
+	process, err := Start(commandArgs...)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return process.stdin, process.stdout, process.stderr, nil
+}
+
+func (p *Process) Stdin() chan<- string {
+	return p.stdin
+}
+
+func (p *Process) Stdout() <-chan string {
+	return p.stdout
+}
+
+func (p *Process) Stderr() <-chan string {
+	return p.stderr
+}
+
+func (p *Process) Pid() int {
+	return p.cmd.Process.Pid
+}
+
+// Kills the process and, on Linux, its descendants. Stdout and Stderr are
+// closed once they're drained.
+func (p *Process) Kill() error {
+	if err := killProcessGroup(p.cmd); err != nil {
+		return fmt.Errorf("killProcessGroup: %w", err)
+	}
+	return nil
+}
+
+// Closed once the process exits and Stdout and Stderr, or Events, are drained
+func (p *Process) Exited() <-chan struct{} {
+	return p.exited
+}
+
+// Waits for the process to exit. Stdout and Stderr, or Events, must be
+// drained. Returns *ExitError when the process exits unsuccessfully.
+func (p *Process) Wait() error {
+	<-p.exited
+	return p.waitErr
+}
+
+var _ Transport = (*Process)(nil)
@@ -0,0 +1,170 @@
+package ipctest
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/zaydek/go-ipc-test/go/pkg/ipc"
+)
+
+// Returned by Wait after Kill
+var ErrKilled = errors.New("ipctest: killed")
+
+// Responds to a line the fake process reads from stdin, e.g. by writing stdout
+// lines or exiting
+type Responder func(p *Process, line string)
+
+// Returns a Responder that responds to exact lines, e.g. "build", with scripted
+// stdout lines. Other lines, e.g. JSON-RPC pings, are never responded to.
+func Script(responses map[string][]string) Responder {
+	return func(p *Process, line string) {
+		if lines, ok := responses[line]; ok {
+			p.WriteStdout(lines...)
+		}
+	}
+}
+
+// An in-memory fake of a long-lived IPC process so code that uses package ipc
+// can be tested without Node. stdout lines are queued so writes never block;
+// stderr text is sent once after stdout is closed, like ipc.Process.
+type Process struct {
+	stdin  chan string
+	stdout chan string
+	stderr chan string
+	exited chan struct{}
+
+	respond Responder
+
+	mu         sync.Mutex
+	cond       *sync.Cond
+	queue      []string
+	received   []string
+	exiting    bool
+	stderrText string
+	waitErr    error
+}
+
+// Starts a fake process that writes stdout lines, e.g. a hello message, and
+// then responds to stdin lines with respond, which can be nil
+func NewProcess(respond Responder, stdout ...string) *Process {
+	p := &Process{
+		stdin:   make(chan string),
+		stdout:  make(chan string),
+		stderr:  make(chan string),
+		exited:  make(chan struct{}),
+		respond: respond,
+		queue:   stdout,
+	}
+	p.cond = sync.NewCond(&p.mu)
+	go p.readLoop()
+	go p.writeLoop()
+	return p
+}
+
+// Records and responds to stdin lines. Lines are drained after the process
+// exits so senders never block, like ipc.Process.
+func (p *Process) readLoop() {
+	for line := range p.stdin {
+		p.mu.Lock()
+		p.received = append(p.received, line)
+		exiting := p.exiting
+		p.mu.Unlock()
+		if !exiting && p.respond != nil {
+			p.respond(p, line)
+		}
+	}
+}
+
+// Flushes queued stdout lines and then closes stdout and stderr on exit
+func (p *Process) writeLoop() {
+	for {
+		p.mu.Lock()
+		for len(p.queue) == 0 && !p.exiting {
+			p.cond.Wait()
+		}
+		if len(p.queue) == 0 {
+			p.mu.Unlock()
+			break
+		}
+		line := p.queue[0]
+		p.queue = p.queue[1:]
+		p.mu.Unlock()
+		p.stdout <- line
+	}
+	close(p.stdout)
+
+	p.mu.Lock()
+	stderrText := p.stderrText
+	p.mu.Unlock()
+	if stderrText != "" {
+		p.stderr <- stderrText
+	}
+	close(p.stderr)
+	close(p.exited)
+}
+
+// Queues stdout lines. Lines written after the process exits are discarded.
+func (p *Process) WriteStdout(lines ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.exiting {
+		return
+	}
+	p.queue = append(p.queue, lines...)
+	p.cond.Signal()
+}
+
+func (p *Process) exit(stderrText string, waitErr error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.exiting {
+		return
+	}
+	p.exiting = true
+	p.stderrText = stderrText
+	p.waitErr = waitErr
+	p.cond.Signal()
+}
+
+// Exits after queued stdout lines are read, writing stderrText to stderr, e.g.
+// an uncaught exception
+func (p *Process) Exit(stderrText string) {
+	p.exit(stderrText, nil)
+}
+
+// Returns the stdin lines read so far
+func (p *Process) Received() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.received...)
+}
+
+func (p *Process) Stdin() chan<- string {
+	return p.stdin
+}
+
+func (p *Process) Stdout() <-chan string {
+	return p.stdout
+}
+
+func (p *Process) Stderr() <-chan string {
+	return p.stderr
+}
+
+func (p *Process) Kill() error {
+	p.exit("", ErrKilled)
+	return nil
+}
+
+func (p *Process) Exited() <-chan struct{} {
+	return p.exited
+}
+
+func (p *Process) Wait() error {
+	<-p.exited
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.waitErr
+}
+
+var _ ipc.Transport = (*Process)(nil)
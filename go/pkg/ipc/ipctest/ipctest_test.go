@@ -0,0 +1,46 @@
+package ipctest
+
+import (
+	"testing"
+
+	"github.com/zaydek/go-ipc-test/go/pkg/expect"
+)
+
+func TestProcess(t *testing.T) {
+	p := NewProcess(Script(map[string][]string{
+		"build": {"building", "done"},
+	}), "hello")
+	expect.DeepEqual(t, <-p.Stdout(), "hello")
+
+	p.Stdin() <- "build"
+	expect.DeepEqual(t, <-p.Stdout(), "building")
+	expect.DeepEqual(t, <-p.Stdout(), "done")
+
+	// Unscripted lines are never responded to
+	p.Stdin() <- "ping"
+	p.Stdin() <- "build"
+	expect.DeepEqual(t, <-p.Stdout(), "building")
+	expect.DeepEqual(t, <-p.Stdout(), "done")
+	expect.DeepEqual(t, p.Received(), []string{"build", "ping", "build"})
+
+	p.Exit("Error: boom")
+	_, ok := <-p.Stdout()
+	expect.DeepEqual(t, ok, false)
+	expect.DeepEqual(t, <-p.Stderr(), "Error: boom")
+	expect.DeepEqual(t, p.Wait(), nil)
+
+	// Writes after exit never block
+	p.Stdin() <- "build"
+}
+
+func TestProcessKill(t *testing.T) {
+	p := NewProcess(nil)
+	if err := p.Kill(); err != nil {
+		t.Fatalf("Kill: %s", err)
+	}
+	for range p.Stdout() {
+	}
+	for range p.Stderr() {
+	}
+	expect.DeepEqual(t, p.Wait(), ErrKilled)
+}
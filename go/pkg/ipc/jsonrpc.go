@@ -0,0 +1,674 @@
+package ipc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// https://www.jsonrpc.org/specification
+const jsonrpcVersion = "2.0"
+
+// Standard JSON-RPC 2.0 error codes
+const (
+	ParseError     = -32700
+	InvalidRequest = -32600
+	MethodNotFound = -32601
+	InvalidParams  = -32602
+	InternalError  = -32603
+)
+
+// Describes a request or, when ID is absent, a notification
+type Request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+func (r *Request) IsNotification() bool {
+	return r.ID == nil
+}
+
+// Describes a response. Exactly one of Result or Error is set.
+type Response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+// Describes a JSON-RPC error object
+type Error struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("jsonrpc: %s (code %d)", e.Message, e.Code)
+}
+
+var ErrClosed = errors.New("jsonrpc: client closed")
+
+// Serves a request from the child process. Return an *Error to control the
+// error code; other errors are sent as InternalError.
+type HandlerFunc func(params json.RawMessage) (result interface{}, err error)
+
+// Describes methods the child process can call, by name
+type Handlers struct {
+	mu       sync.RWMutex
+	handlers map[string]HandlerFunc
+}
+
+func (h *Handlers) Handle(method string, handler HandlerFunc) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.handlers == nil {
+		h.handlers = map[string]HandlerFunc{}
+	}
+	h.handlers[method] = handler
+}
+
+func (h *Handlers) lookup(method string) (HandlerFunc, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	handler, ok := h.handlers[method]
+	return handler, ok
+}
+
+// Handlers shared by every client; client handlers take precedence
+var DefaultHandlers = &Handlers{}
+
+// Registers a handler on DefaultHandlers
+func Handle(method string, handler HandlerFunc) {
+	DefaultHandlers.Handle(method, handler)
+}
+
+func marshalParams(params interface{}) (json.RawMessage, error) {
+	if params == nil {
+		return nil, nil
+	}
+	byteStr, err := json.Marshal(params)
+	if err != nil {
+		return nil, fmt.Errorf("json.Marshal: %w", err)
+	}
+	return byteStr, nil
+}
+
+func NewRequest(id int64, method string, params interface{}) (*Request, error) {
+	rawParams, err := marshalParams(params)
+	if err != nil {
+		return nil, err
+	}
+	request := &Request{
+		JSONRPC: jsonrpcVersion,
+		ID:      json.RawMessage(strconv.FormatInt(id, 10)),
+		Method:  method,
+		Params:  rawParams,
+	}
+	return request, nil
+}
+
+func NewNotification(method string, params interface{}) (*Request, error) {
+	rawParams, err := marshalParams(params)
+	if err != nil {
+		return nil, err
+	}
+	request := &Request{
+		JSONRPC: jsonrpcVersion,
+		Method:  method,
+		Params:  rawParams,
+	}
+	return request, nil
+}
+
+func NewResponse(id json.RawMessage, result interface{}) (*Response, error) {
+	byteStr, err := json.Marshal(result)
+	if err != nil {
+		return nil, fmt.Errorf("json.Marshal: %w", err)
+	}
+	response := &Response{
+		JSONRPC: jsonrpcVersion,
+		ID:      id,
+		Result:  byteStr,
+	}
+	return response, nil
+}
+
+func NewErrorResponse(id json.RawMessage, code int, message string) *Response {
+	if id == nil {
+		// The ID couldn't be determined, e.g. parse errors
+		id = json.RawMessage("null")
+	}
+	response := &Response{
+		JSONRPC: jsonrpcVersion,
+		ID:      id,
+		Error:   &Error{Code: code, Message: message},
+	}
+	return response
+}
+
+// Describes a decoded line. A line can be a single request or response or a
+// batch of requests and responses.
+type Messages struct {
+	Requests  []*Request
+	Responses []*Response
+	Batch     bool
+
+	// Error responses to invalid elements of a batch, which must be sent back
+	// with the batch's other responses
+	Invalid []*Response
+}
+
+// Describes a request or response before it's known which
+type rawMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	Result  json.RawMessage `json:"result"`
+	Error   *Error          `json:"error"`
+}
+
+// Returns whether line looks like a JSON-RPC message, i.e. an object or array
+// with a "jsonrpc" member. Lines that aren't JSON-RPC messages are logs.
+func IsMessage(line []byte) bool {
+	line = bytes.TrimSpace(line)
+	return (bytes.HasPrefix(line, []byte("{")) || bytes.HasPrefix(line, []byte("["))) &&
+		bytes.Contains(line, []byte(`"jsonrpc"`))
+}
+
+// Decodes a single message or a batch of messages. Returns an *Error with
+// ParseError or InvalidRequest when line is malformed. Invalid elements of an
+// otherwise valid batch don't fail the batch; they're described by Invalid.
+func DecodeMessages(line []byte) (Messages, error) {
+	var messages Messages
+
+	line = bytes.TrimSpace(line)
+	if !bytes.HasPrefix(line, []byte("[")) {
+		var raw rawMessage
+		if err := json.Unmarshal(line, &raw); err != nil {
+			return Messages{}, &Error{Code: ParseError, Message: err.Error()}
+		}
+		if err := messages.add(raw); err != nil {
+			return Messages{}, err
+		}
+		return messages, nil
+	}
+
+	messages.Batch = true
+	var elements []json.RawMessage
+	if err := json.Unmarshal(line, &elements); err != nil {
+		return Messages{}, &Error{Code: ParseError, Message: err.Error()}
+	}
+	if len(elements) == 0 {
+		return Messages{}, &Error{Code: InvalidRequest, Message: "empty batch"}
+	}
+	for _, element := range elements {
+		var raw rawMessage
+		if err := json.Unmarshal(element, &raw); err != nil {
+			messages.Invalid = append(messages.Invalid, NewErrorResponse(nil, InvalidRequest, err.Error()))
+			continue
+		}
+		if err := messages.add(raw); err != nil {
+			messages.Invalid = append(messages.Invalid, NewErrorResponse(raw.ID, err.Code, err.Message))
+		}
+	}
+	return messages, nil
+}
+
+// Adds a decoded request or response. Returns an *Error with InvalidRequest
+// when raw is neither.
+func (m *Messages) add(raw rawMessage) *Error {
+	if raw.JSONRPC != jsonrpcVersion {
+		return &Error{Code: InvalidRequest, Message: fmt.Sprintf("unsupported version %q", raw.JSONRPC)}
+	}
+	if raw.Method != "" {
+		m.Requests = append(m.Requests, &Request{
+			JSONRPC: raw.JSONRPC,
+			ID:      raw.ID,
+			Method:  raw.Method,
+			Params:  raw.Params,
+		})
+		return nil
+	}
+	if raw.ID == nil || (raw.Result == nil && raw.Error == nil) {
+		return &Error{Code: InvalidRequest, Message: "neither a request nor a response"}
+	}
+	m.Responses = append(m.Responses, &Response{
+		JSONRPC: raw.JSONRPC,
+		ID:      raw.ID,
+		Result:  raw.Result,
+		Error:   raw.Error,
+	})
+	return nil
+}
+
+// Encodes a request, response, or batch of requests and responses as a single
+// line
+func Encode(messages ...interface{}) (string, error) {
+	for _, message := range messages {
+		// Successful responses must have a result, even when null
+		if response, ok := message.(*Response); ok && response.Error == nil && response.Result == nil {
+			response.Result = json.RawMessage("null")
+		}
+	}
+
+	var byteStr []byte
+	var err error
+	if len(messages) == 1 {
+		byteStr, err = json.Marshal(messages[0])
+	} else {
+		byteStr, err = json.Marshal(messages)
+	}
+	if err != nil {
+		return "", fmt.Errorf("json.Marshal: %w", err)
+	}
+	return string(byteStr), nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Describes one call in a batch
+type BatchCall struct {
+	Method string
+	Params interface{}
+	Result interface{} // Decoded in place
+	Error  error
+}
+
+// A JSON-RPC 2.0 client over line-based IPC, e.g. the stdin and stdout
+// channels from NewCommand. stdout lines that aren't JSON-RPC messages are
+// forwarded to Stdout so plugins can still log; Stdout must be drained or
+// responses aren't read and calls block. Requests from the child process
+// are served by the client's handlers, or DefaultHandlers, and responses are
+// routed back by ID over stdin.
+type Client struct {
+	stdin  chan<- string
+	stdout chan string
+
+	handlers Handlers
+
+	mu      sync.Mutex
+	nextID  int64
+	pending map[string]chan *Response
+	closed  bool
+	hooks   []Hooks
+}
+
+func NewClient(stdin chan<- string, stdout <-chan string) *Client {
+	client := &Client{
+		stdin:   stdin,
+		stdout:  make(chan string),
+		pending: map[string]chan *Response{},
+	}
+	go client.readLoop(stdout)
+	return client
+}
+
+// Registers a handler for requests from the child process
+func (c *Client) Handle(method string, handler HandlerFunc) {
+	c.handlers.Handle(method, handler)
+}
+
+// Observes lines a Client sends and receives, e.g. to collect metrics or trace
+// calls. Any function can be nil. Hooks are called synchronously so they must
+// not block.
+type Hooks struct {
+	BeforeSend   func(line string)        // Called before a line is sent over stdin
+	AfterReceive func(line string)        // Called when a stdout line is read, before it's routed
+	AfterCancel  func(id json.RawMessage) // Called when a call stops waiting for its response, e.g. on timeout
+	AfterClose   func()                   // Called once stdout is closed, before pending calls fail
+}
+
+// Adds hooks, which are called after previously added hooks
+func (c *Client) Use(hooks Hooks) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.hooks = append(c.hooks, hooks)
+}
+
+func (c *Client) beforeSend(line string) {
+	c.mu.Lock()
+	hooks := c.hooks
+	c.mu.Unlock()
+	for _, hook := range hooks {
+		if hook.BeforeSend != nil {
+			hook.BeforeSend(line)
+		}
+	}
+}
+
+func (c *Client) afterReceive(line string) {
+	c.mu.Lock()
+	hooks := c.hooks
+	c.mu.Unlock()
+	for _, hook := range hooks {
+		if hook.AfterReceive != nil {
+			hook.AfterReceive(line)
+		}
+	}
+}
+
+func (c *Client) afterCancel(id json.RawMessage) {
+	c.mu.Lock()
+	hooks := c.hooks
+	c.mu.Unlock()
+	for _, hook := range hooks {
+		if hook.AfterCancel != nil {
+			hook.AfterCancel(id)
+		}
+	}
+}
+
+func (c *Client) afterClose() {
+	c.mu.Lock()
+	hooks := c.hooks
+	c.mu.Unlock()
+	for _, hook := range hooks {
+		if hook.AfterClose != nil {
+			hook.AfterClose()
+		}
+	}
+}
+
+// Serves a request. Returns nil for notifications, which have no response.
+func (c *Client) serve(request *Request) *Response {
+	handler, ok := c.handlers.lookup(request.Method)
+	if !ok {
+		handler, ok = DefaultHandlers.lookup(request.Method)
+	}
+
+	var response *Response
+	if !ok {
+		response = NewErrorResponse(request.ID, MethodNotFound, fmt.Sprintf("method not found: %s", request.Method))
+	} else if result, err := handler(request.Params); err != nil {
+		var rpcErr *Error
+		if errors.As(err, &rpcErr) {
+			response = &Response{JSONRPC: jsonrpcVersion, ID: request.ID, Error: rpcErr}
+		} else {
+			response = NewErrorResponse(request.ID, InternalError, err.Error())
+		}
+	} else if response, err = NewResponse(request.ID, result); err != nil {
+		response = NewErrorResponse(request.ID, InternalError, err.Error())
+	}
+
+	if request.IsNotification() {
+		return nil
+	}
+	return response
+}
+
+// Serves requests concurrently so handlers never block the read loop, then
+// sends the responses, including errors for invalid batch elements, as one
+// message or one batch
+func (c *Client) serveAll(messages Messages) {
+	requests := messages.Requests
+	responses := make([]*Response, len(requests))
+	var wg sync.WaitGroup
+	for requestIndex, request := range requests {
+		wg.Add(1)
+		go func(requestIndex int, request *Request) {
+			defer wg.Done()
+			responses[requestIndex] = c.serve(request)
+		}(requestIndex, request)
+	}
+	wg.Wait()
+
+	var replies []interface{}
+	for _, response := range responses {
+		if response != nil {
+			replies = append(replies, response)
+		}
+	}
+	for _, response := range messages.Invalid {
+		replies = append(replies, response)
+	}
+	if len(replies) == 0 {
+		return
+	}
+
+	var line string
+	var err error
+	if messages.Batch {
+		var byteStr []byte
+		byteStr, err = json.Marshal(replies)
+		line = string(byteStr)
+	} else {
+		line, err = Encode(replies[0])
+	}
+	if err != nil {
+		// Unreachable; responses are made of raw JSON
+		return
+	}
+
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+	if !closed {
+		c.beforeSend(line)
+		c.stdin <- line
+	}
+}
+
+// Unencoded stdout lines, e.g. logs. Closed when the process's stdout is
+// closed.
+func (c *Client) Stdout() <-chan string {
+	return c.stdout
+}
+
+func (c *Client) readLoop(stdout <-chan string) {
+	defer func() {
+		c.afterClose()
+		c.mu.Lock()
+		c.closed = true
+		for id, ch := range c.pending {
+			close(ch)
+			delete(c.pending, id)
+		}
+		c.mu.Unlock()
+		close(c.stdout)
+	}()
+	for line := range stdout {
+		c.afterReceive(line)
+		if !IsMessage([]byte(line)) {
+			c.stdout <- line
+			continue
+		}
+		messages, err := DecodeMessages([]byte(line))
+		if err != nil {
+			c.stdout <- line
+			continue
+		}
+		if len(messages.Requests) > 0 || len(messages.Invalid) > 0 {
+			go c.serveAll(messages)
+		}
+		for _, response := range messages.Responses {
+			c.mu.Lock()
+			ch, ok := c.pending[string(response.ID)]
+			delete(c.pending, string(response.ID))
+			c.mu.Unlock()
+			if ok {
+				ch <- response
+			}
+		}
+	}
+}
+
+// Registers a pending request and returns its request
+func (c *Client) newRequest(method string, params interface{}) (*Request, chan *Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, nil, ErrClosed
+	}
+	c.nextID++
+	request, err := NewRequest(c.nextID, method, params)
+	if err != nil {
+		return nil, nil, err
+	}
+	ch := make(chan *Response, 1)
+	c.pending[string(request.ID)] = ch
+	return request, ch, nil
+}
+
+func decodeResult(response *Response, result interface{}) error {
+	if response == nil {
+		return ErrClosed
+	}
+	if response.Error != nil {
+		return response.Error
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(response.Result, result); err != nil {
+		return fmt.Errorf("json.Unmarshal: %w", err)
+	}
+	return nil
+}
+
+// Calls method and decodes the result into result, which can be nil
+func (c *Client) Call(method string, params, result interface{}) error {
+	return c.CallContext(context.Background(), method, params, result)
+}
+
+// Like Call but gives up when ctx is done, e.g. when a deadline passes. Late
+// responses are discarded.
+func (c *Client) CallContext(ctx context.Context, method string, params, result interface{}) error {
+	request, ch, err := c.newRequest(method, params)
+	if err != nil {
+		return err
+	}
+	line, err := Encode(request)
+	if err != nil {
+		c.forget(request.ID)
+		return err
+	}
+	c.beforeSend(line)
+	select {
+	case c.stdin <- line:
+	case <-ctx.Done():
+		c.forget(request.ID)
+		return ctx.Err()
+	}
+	select {
+	case response := <-ch:
+		return decodeResult(response, result)
+	case <-ctx.Done():
+		c.forget(request.ID)
+		return ctx.Err()
+	}
+}
+
+// Forgets a pending request so a late response is discarded
+func (c *Client) forget(id json.RawMessage) {
+	c.mu.Lock()
+	delete(c.pending, string(id))
+	c.mu.Unlock()
+	c.afterCancel(id)
+}
+
+// The method Heartbeat calls
+const HeartbeatMethod = "ping"
+
+// Calls HeartbeatMethod every interval and sends an error when the child process doesn't
+// respond within timeout, e.g. when it's wedged in an infinite loop. The
+// heartbeat stops after the first failure or when stop is called.
+func (c *Client) Heartbeat(interval, timeout time.Duration) (failed <-chan error, stop func()) {
+	ch := make(chan error, 1)
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), timeout)
+				err := c.CallContext(ctx, HeartbeatMethod, nil, nil)
+				cancel()
+				if err != nil {
+					ch <- fmt.Errorf("ping: %w", err)
+					return
+				}
+			}
+		}
+	}()
+	var once sync.Once
+	return ch, func() { once.Do(func() { close(done) }) }
+}
+
+// Sends a notification; notifications have no response
+func (c *Client) Notify(method string, params interface{}) error {
+	request, err := NewNotification(method, params)
+	if err != nil {
+		return err
+	}
+	line, err := Encode(request)
+	if err != nil {
+		return err
+	}
+	c.beforeSend(line)
+	c.stdin <- line
+	return nil
+}
+
+// Sends calls as a single batch. Per-call errors are set on each BatchCall;
+// the returned error describes the batch as a whole.
+func (c *Client) CallBatch(calls []*BatchCall) error {
+	return c.CallBatchContext(context.Background(), calls)
+}
+
+// Like CallBatch but gives up when ctx is done, e.g. when a deadline passes.
+// Calls without a response by then are left unset; late responses are
+// discarded.
+func (c *Client) CallBatchContext(ctx context.Context, calls []*BatchCall) error {
+	requests := make([]interface{}, 0, len(calls))
+	ids := make([]json.RawMessage, 0, len(calls))
+	chs := make([]chan *Response, 0, len(calls))
+	forgetAll := func() {
+		for _, id := range ids {
+			c.forget(id)
+		}
+	}
+	for _, call := range calls {
+		request, ch, err := c.newRequest(call.Method, call.Params)
+		if err != nil {
+			forgetAll()
+			return err
+		}
+		requests = append(requests, request)
+		ids = append(ids, request.ID)
+		chs = append(chs, ch)
+	}
+	byteStr, err := json.Marshal(requests)
+	if err != nil {
+		forgetAll()
+		return fmt.Errorf("json.Marshal: %w", err)
+	}
+	c.beforeSend(string(byteStr))
+	select {
+	case c.stdin <- string(byteStr):
+	case <-ctx.Done():
+		forgetAll()
+		return ctx.Err()
+	}
+	for callIndex, call := range calls {
+		select {
+		case response := <-chs[callIndex]:
+			call.Error = decodeResult(response, call.Result)
+		case <-ctx.Done():
+			forgetAll()
+			return ctx.Err()
+		}
+	}
+	return nil
+}
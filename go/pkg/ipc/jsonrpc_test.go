@@ -0,0 +1,279 @@
+package ipc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/zaydek/go-ipc-test/go/pkg/expect"
+)
+
+func TestDecodeMessagesRequest(t *testing.T) {
+	messages, err := DecodeMessages([]byte(`{"jsonrpc":"2.0","id":1,"method":"build","params":{"foo":"bar"}}`))
+	if err != nil {
+		t.Fatalf("DecodeMessages: %s", err)
+	}
+	expect.DeepEqual(t, len(messages.Requests), 1)
+	expect.DeepEqual(t, messages.Batch, false)
+	expect.DeepEqual(t, messages.Requests[0].Method, "build")
+	expect.DeepEqual(t, messages.Requests[0].IsNotification(), false)
+	expect.DeepEqual(t, string(messages.Requests[0].Params), `{"foo":"bar"}`)
+}
+
+func TestDecodeMessagesBatch(t *testing.T) {
+	messages, err := DecodeMessages([]byte(`[
+		{"jsonrpc":"2.0","method":"log"},
+		{"jsonrpc":"2.0","id":2,"result":null},
+		{"jsonrpc":"2.0","id":3,"error":{"code":-32601,"message":"method not found"}}
+	]`))
+	if err != nil {
+		t.Fatalf("DecodeMessages: %s", err)
+	}
+	expect.DeepEqual(t, messages.Batch, true)
+	expect.DeepEqual(t, len(messages.Requests), 1)
+	expect.DeepEqual(t, messages.Requests[0].IsNotification(), true)
+	expect.DeepEqual(t, len(messages.Responses), 2)
+	expect.DeepEqual(t, string(messages.Responses[0].Result), "null")
+	expect.DeepEqual(t, messages.Responses[1].Error.Code, MethodNotFound)
+}
+
+func TestDecodeMessagesErrors(t *testing.T) {
+	_, err := DecodeMessages([]byte(`{"jsonrpc":`))
+	expect.DeepEqual(t, err.(*Error).Code, ParseError)
+
+	_, err = DecodeMessages([]byte(`[]`))
+	expect.DeepEqual(t, err.(*Error).Code, InvalidRequest)
+
+	_, err = DecodeMessages([]byte(`{"jsonrpc":"1.0","id":1,"method":"build"}`))
+	expect.DeepEqual(t, err.(*Error).Code, InvalidRequest)
+
+	// Invalid batch elements don't fail the batch
+	messages, err := DecodeMessages([]byte(`[{"jsonrpc":"2.0","method":"log"},1,{"jsonrpc":"1.0","id":2,"method":"build"}]`))
+	if err != nil {
+		t.Fatalf("DecodeMessages: %s", err)
+	}
+	expect.DeepEqual(t, len(messages.Requests), 1)
+	expect.DeepEqual(t, len(messages.Invalid), 2)
+	expect.DeepEqual(t, string(messages.Invalid[0].ID), "null")
+	expect.DeepEqual(t, messages.Invalid[0].Error.Code, InvalidRequest)
+	expect.DeepEqual(t, string(messages.Invalid[1].ID), "2")
+	expect.DeepEqual(t, messages.Invalid[1].Error.Code, InvalidRequest)
+}
+
+func TestEncode(t *testing.T) {
+	request, err := NewRequest(1, "build", []string{"foo"})
+	if err != nil {
+		t.Fatalf("NewRequest: %s", err)
+	}
+	line, err := Encode(request)
+	if err != nil {
+		t.Fatalf("Encode: %s", err)
+	}
+	expect.DeepEqual(t, line, `{"jsonrpc":"2.0","id":1,"method":"build","params":["foo"]}`)
+
+	line, err = Encode(&Response{JSONRPC: "2.0", ID: json.RawMessage("1")}, NewErrorResponse(nil, ParseError, "parse error"))
+	if err != nil {
+		t.Fatalf("Encode: %s", err)
+	}
+	expect.DeepEqual(t, line, `[{"jsonrpc":"2.0","id":1,"result":null},{"jsonrpc":"2.0","id":null,"error":{"code":-32700,"message":"parse error"}}]`)
+}
+
+// Echoes params for "echo" and fails every other method
+func serveEcho(t *testing.T, stdin <-chan string, stdout chan<- string) {
+	for line := range stdin {
+		messages, err := DecodeMessages([]byte(line))
+		if err != nil {
+			t.Errorf("DecodeMessages: %s", err)
+			return
+		}
+		var responses []interface{}
+		for _, request := range messages.Requests {
+			if request.IsNotification() {
+				stdout <- "notified " + request.Method
+				continue
+			}
+			if request.Method != "echo" {
+				responses = append(responses, NewErrorResponse(request.ID, MethodNotFound, "method not found"))
+				continue
+			}
+			responses = append(responses, &Response{JSONRPC: "2.0", ID: request.ID, Result: request.Params})
+		}
+		if len(responses) == 0 {
+			continue
+		}
+		byteStr, err := json.Marshal(responses)
+		if !messages.Batch {
+			byteStr, err = json.Marshal(responses[0])
+		}
+		if err != nil {
+			t.Errorf("json.Marshal: %s", err)
+			return
+		}
+		stdout <- string(byteStr)
+	}
+}
+
+func TestClient(t *testing.T) {
+	stdin := make(chan string)
+	stdout := make(chan string)
+	go serveEcho(t, stdin, stdout)
+
+	client := NewClient(stdin, stdout)
+
+	var result string
+	if err := client.Call("echo", "foo", &result); err != nil {
+		t.Fatalf("Call: %s", err)
+	}
+	expect.DeepEqual(t, result, "foo")
+
+	err := client.Call("missing", nil, nil)
+	rpcErr, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("Call: got %v want *Error", err)
+	}
+	expect.DeepEqual(t, rpcErr.Code, MethodNotFound)
+
+	var result1, result2 int
+	calls := []*BatchCall{
+		{Method: "echo", Params: 1, Result: &result1},
+		{Method: "missing"},
+		{Method: "echo", Params: 2, Result: &result2},
+	}
+	if err := client.CallBatch(calls); err != nil {
+		t.Fatalf("CallBatch: %s", err)
+	}
+	expect.DeepEqual(t, result1, 1)
+	expect.DeepEqual(t, calls[1].Error.(*Error).Code, MethodNotFound)
+	expect.DeepEqual(t, result2, 2)
+}
+
+func TestClientStdout(t *testing.T) {
+	stdin := make(chan string)
+	stdout := make(chan string)
+	go serveEcho(t, stdin, stdout)
+
+	client := NewClient(stdin, stdout)
+	if err := client.Notify("log", nil); err != nil {
+		t.Fatalf("Notify: %s", err)
+	}
+	expect.DeepEqual(t, <-client.Stdout(), "notified log")
+
+	close(stdout)
+	_, ok := <-client.Stdout()
+	expect.DeepEqual(t, ok, false)
+	expect.DeepEqual(t, client.Call("echo", "foo", nil), ErrClosed)
+}
+
+func TestClientHandle(t *testing.T) {
+	stdin := make(chan string)
+	stdout := make(chan string)
+
+	defaultHandlers := DefaultHandlers
+	DefaultHandlers = &Handlers{}
+	t.Cleanup(func() { DefaultHandlers = defaultHandlers })
+	Handle("version", func(json.RawMessage) (interface{}, error) {
+		return "1.0.0", nil
+	})
+	client := NewClient(stdin, stdout)
+	client.Handle("readManifest", func(params json.RawMessage) (interface{}, error) {
+		var path string
+		if err := json.Unmarshal(params, &path); err != nil {
+			return nil, &Error{Code: InvalidParams, Message: err.Error()}
+		}
+		return map[string]string{"path": path}, nil
+	})
+
+	stdout <- `{"jsonrpc":"2.0","id":1,"method":"readManifest","params":"out/manifest.json"}`
+	expect.DeepEqual(t, <-stdin, `{"jsonrpc":"2.0","id":1,"result":{"path":"out/manifest.json"}}`)
+
+	stdout <- `{"jsonrpc":"2.0","id":"2","method":"readManifest","params":1}`
+	expect.DeepEqual(t, <-stdin, `{"jsonrpc":"2.0","id":"2","error":{"code":-32602,"message":"json: cannot unmarshal number into Go value of type string"}}`)
+
+	// Falls back to DefaultHandlers
+	stdout <- `[{"jsonrpc":"2.0","id":3,"method":"version"},{"jsonrpc":"2.0","id":4,"method":"missing"},{"jsonrpc":"2.0","method":"version"}]`
+	expect.DeepEqual(t, <-stdin, `[{"jsonrpc":"2.0","id":3,"result":"1.0.0"},{"jsonrpc":"2.0","id":4,"error":{"code":-32601,"message":"method not found: missing"}}]`)
+}
+
+func TestClientInvalidBatch(t *testing.T) {
+	stdin := make(chan string)
+	stdout := make(chan string)
+	NewClient(stdin, stdout)
+
+	stdout <- `[{"jsonrpc":"2.0","id":1,"method":"missing"},{"jsonrpc":"2.0","id":2}]`
+	expect.DeepEqual(t, <-stdin, `[{"jsonrpc":"2.0","id":1,"error":{"code":-32601,"message":"method not found: missing"}},`+
+		`{"jsonrpc":"2.0","id":2,"error":{"code":-32600,"message":"neither a request nor a response"}}]`)
+}
+
+func TestClientCallContext(t *testing.T) {
+	stdin := make(chan string)
+	stdout := make(chan string)
+	go func() {
+		for range stdin {
+			// Never respond
+		}
+	}()
+
+	client := NewClient(stdin, stdout)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	expect.DeepEqual(t, client.CallContext(ctx, "echo", "foo", nil), context.DeadlineExceeded)
+
+	// Late responses are discarded
+	stdout <- `{"jsonrpc":"2.0","id":1,"result":"foo"}`
+}
+
+func TestClientHeartbeat(t *testing.T) {
+	stdin := make(chan string)
+	stdout := make(chan string)
+	go func() {
+		// Respond to the first ping and then hang
+		line := <-stdin
+		messages, err := DecodeMessages([]byte(line))
+		if err != nil {
+			t.Errorf("DecodeMessages: %s", err)
+			return
+		}
+		response, err := NewResponse(messages.Requests[0].ID, "pong")
+		if err != nil {
+			t.Errorf("NewResponse: %s", err)
+			return
+		}
+		line, err = Encode(response)
+		if err != nil {
+			t.Errorf("Encode: %s", err)
+			return
+		}
+		stdout <- line
+		for range stdin {
+		}
+	}()
+
+	client := NewClient(stdin, stdout)
+	failed, stop := client.Heartbeat(time.Millisecond, 10*time.Millisecond)
+	defer stop()
+	expect.DeepEqual(t, errors.Is(<-failed, context.DeadlineExceeded), true)
+}
+
+func TestClientCallBatchContext(t *testing.T) {
+	stdin := make(chan string)
+	stdout := make(chan string)
+	go func() {
+		for range stdin {
+			// Never respond
+		}
+	}()
+
+	client := NewClient(stdin, stdout)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	calls := []*BatchCall{{Method: "echo", Params: 1}, {Method: "echo", Params: 2}}
+	expect.DeepEqual(t, client.CallBatchContext(ctx, calls), context.DeadlineExceeded)
+	expect.DeepEqual(t, len(client.pending), 0)
+
+	// Calls registered before an unencodable call are forgotten
+	calls = []*BatchCall{{Method: "echo", Params: 1}, {Method: "echo", Params: make(chan int)}}
+	expect.DeepEqual(t, client.CallBatch(calls) != nil, true)
+	expect.DeepEqual(t, len(client.pending), 0)
+}
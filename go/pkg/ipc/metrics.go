@@ -0,0 +1,264 @@
+package ipc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Upper bounds of the latency histogram buckets, in seconds
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
+
+// Collects per-action latency histograms, payload sizes, and error counts.
+// Actions are JSON-RPC methods observed with Hooks or anything else observed
+// with Observe, e.g. a build.
+type Metrics struct {
+	mu      sync.Mutex
+	actions map[string]*actionMetrics
+}
+
+type actionMetrics struct {
+	buckets  []uint64 // Per latencyBuckets; not cumulative
+	count    uint64
+	sum      time.Duration
+	max      time.Duration
+	sent     uint64 // Bytes
+	received uint64 // Bytes
+	errors   uint64
+}
+
+// Times one client's JSON-RPC calls; see Metrics.Hooks
+type tracer struct {
+	metrics *Metrics
+	ignore  map[string]bool // Methods that aren't observed
+
+	mu      sync.Mutex
+	pending map[string]pendingCall
+}
+
+// A JSON-RPC request that's waiting for its response
+type pendingCall struct {
+	method string
+	start  time.Time
+	size   int
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		actions: map[string]*actionMetrics{},
+	}
+}
+
+// Records one action that took elapsed, sent and received payloads of the
+// given sizes in bytes, and failed when err is non-nil
+func (m *Metrics) Observe(action string, elapsed time.Duration, sent, received int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a, ok := m.actions[action]
+	if !ok {
+		a = &actionMetrics{buckets: make([]uint64, len(latencyBuckets))}
+		m.actions[action] = a
+	}
+	for bucketIndex, bound := range latencyBuckets {
+		if elapsed.Seconds() <= bound {
+			a.buckets[bucketIndex]++
+			break
+		}
+	}
+	a.count++
+	a.sum += elapsed
+	if elapsed > a.max {
+		a.max = elapsed
+	}
+	a.sent += uint64(sent)
+	a.received += uint64(received)
+	if err != nil {
+		a.errors++
+	}
+}
+
+// Returns hooks that observe one client's JSON-RPC calls in both directions,
+// labeled by method. Error responses are counted as errors. Use new hooks for
+// each client since request IDs restart. Calls that are cancelled or still
+// pending when the client closes aren't observed, and neither are calls to
+// ignored methods, e.g. HeartbeatMethod.
+func (m *Metrics) Hooks(ignore ...string) Hooks {
+	return newTracer(m, ignore...).hooks()
+}
+
+func newTracer(m *Metrics, ignore ...string) *tracer {
+	t := &tracer{metrics: m, ignore: map[string]bool{}, pending: map[string]pendingCall{}}
+	for _, method := range ignore {
+		t.ignore[method] = true
+	}
+	return t
+}
+
+func (t *tracer) hooks() Hooks {
+	return Hooks{
+		BeforeSend: func(line string) {
+			t.trace("sent", "received", line)
+		},
+		AfterReceive: func(line string) {
+			t.trace("received", "sent", line)
+		},
+		AfterCancel: func(id json.RawMessage) {
+			t.mu.Lock()
+			delete(t.pending, "sent"+string(id))
+			t.mu.Unlock()
+		},
+		AfterClose: func() {
+			t.mu.Lock()
+			t.pending = map[string]pendingCall{}
+			t.mu.Unlock()
+		},
+	}
+}
+
+// Starts timing requests and observes responses to requests that went the
+// other way. Requests are keyed by direction because both sides number their
+// requests independently.
+func (t *tracer) trace(direction, reverse string, line string) {
+	if !IsMessage([]byte(line)) {
+		return
+	}
+	messages, err := DecodeMessages([]byte(line))
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	for _, request := range messages.Requests {
+		if request.IsNotification() || t.ignore[request.Method] {
+			continue
+		}
+		t.mu.Lock()
+		t.pending[direction+string(request.ID)] = pendingCall{method: request.Method, start: now, size: len(line)}
+		t.mu.Unlock()
+	}
+	for _, response := range messages.Responses {
+		t.mu.Lock()
+		call, ok := t.pending[reverse+string(response.ID)]
+		delete(t.pending, reverse+string(response.ID))
+		t.mu.Unlock()
+		if !ok {
+			continue
+		}
+		var responseErr error
+		if response.Error != nil {
+			responseErr = response.Error
+		}
+		if direction == "received" {
+			t.metrics.Observe(call.method, now.Sub(call.start), call.size, len(line), responseErr)
+		} else {
+			t.metrics.Observe(call.method, now.Sub(call.start), len(line), call.size, responseErr)
+		}
+	}
+}
+
+// Returns the observed actions in order
+func (m *Metrics) sortedActions() []string {
+	var actions []string
+	for action := range m.actions {
+		actions = append(actions, action)
+	}
+	sort.Strings(actions)
+	return actions
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// Quotes a label value for the Prometheus text exposition format, which only
+// escapes backslashes, double quotes, and newlines
+func prometheusLabel(value string) string {
+	return `"` + prometheusLabelEscaper.Replace(value) + `"`
+}
+
+var prometheusLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Writes the metrics in the Prometheus text exposition format
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var str strings.Builder
+	actions := m.sortedActions()
+
+	str.WriteString("# HELP ipc_action_duration_seconds How long actions take end to end.\n")
+	str.WriteString("# TYPE ipc_action_duration_seconds histogram\n")
+	for _, action := range actions {
+		a := m.actions[action]
+		label := prometheusLabel(action)
+		var cumulative uint64
+		for bucketIndex, bound := range latencyBuckets {
+			cumulative += a.buckets[bucketIndex]
+			fmt.Fprintf(&str, "ipc_action_duration_seconds_bucket{action=%s,le=\"%s\"} %d\n", label, formatFloat(bound), cumulative)
+		}
+		fmt.Fprintf(&str, "ipc_action_duration_seconds_bucket{action=%s,le=\"+Inf\"} %d\n", label, a.count)
+		fmt.Fprintf(&str, "ipc_action_duration_seconds_sum{action=%s} %s\n", label, formatFloat(a.sum.Seconds()))
+		fmt.Fprintf(&str, "ipc_action_duration_seconds_count{action=%s} %d\n", label, a.count)
+	}
+
+	counters := []struct {
+		name  string
+		help  string
+		value func(a *actionMetrics) uint64
+	}{
+		{"ipc_action_sent_bytes_total", "Bytes sent by actions.", func(a *actionMetrics) uint64 { return a.sent }},
+		{"ipc_action_received_bytes_total", "Bytes received by actions.", func(a *actionMetrics) uint64 { return a.received }},
+		{"ipc_action_errors_total", "Actions that failed.", func(a *actionMetrics) uint64 { return a.errors }},
+	}
+	for _, counter := range counters {
+		fmt.Fprintf(&str, "# HELP %s %s\n", counter.name, counter.help)
+		fmt.Fprintf(&str, "# TYPE %s counter\n", counter.name)
+		for _, action := range actions {
+			fmt.Fprintf(&str, "%s{action=%s} %d\n", counter.name, prometheusLabel(action), counter.value(m.actions[action]))
+		}
+	}
+
+	if _, err := io.WriteString(w, str.String()); err != nil {
+		return fmt.Errorf("io.WriteString: %w", err)
+	}
+	return nil
+}
+
+// Formats a byte count, e.g. 2.1 MiB
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for n/div >= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// Writes a table of the metrics, e.g. to print on exit
+func (m *Metrics) WriteSummary(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "action\tcount\tmean\tmax\terrors\tsent\treceived")
+	for _, action := range m.sortedActions() {
+		a := m.actions[action]
+		mean := a.sum / time.Duration(a.count)
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%d\t%s\t%s\n", action, a.count,
+			mean.Round(time.Millisecond), a.max.Round(time.Millisecond), a.errors,
+			formatBytes(a.sent), formatBytes(a.received))
+	}
+	if err := tw.Flush(); err != nil {
+		return fmt.Errorf("tw.Flush: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package ipc
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zaydek/go-ipc-test/go/pkg/expect"
+)
+
+func TestMetricsObserve(t *testing.T) {
+	metrics := NewMetrics()
+	metrics.Observe("build", 20*time.Millisecond, 5, 1000, nil)
+	metrics.Observe("build", 2*time.Second, 5, 2000, errors.New("failed"))
+
+	var str strings.Builder
+	if err := metrics.WritePrometheus(&str); err != nil {
+		t.Fatalf("WritePrometheus: %s", err)
+	}
+	lines := strings.Split(str.String(), "\n")
+	for _, want := range []string{
+		`ipc_action_duration_seconds_bucket{action="build",le="0.01"} 0`,
+		`ipc_action_duration_seconds_bucket{action="build",le="0.025"} 1`,
+		`ipc_action_duration_seconds_bucket{action="build",le="2.5"} 2`,
+		`ipc_action_duration_seconds_bucket{action="build",le="+Inf"} 2`,
+		`ipc_action_duration_seconds_sum{action="build"} 2.02`,
+		`ipc_action_duration_seconds_count{action="build"} 2`,
+		`ipc_action_sent_bytes_total{action="build"} 10`,
+		`ipc_action_received_bytes_total{action="build"} 3000`,
+		`ipc_action_errors_total{action="build"} 1`,
+	} {
+		found := false
+		for _, line := range lines {
+			found = found || line == want
+		}
+		if !found {
+			t.Errorf("WritePrometheus: missing %q", want)
+		}
+	}
+}
+
+func TestMetricsPrometheusLabels(t *testing.T) {
+	metrics := NewMetrics()
+	metrics.Observe("café \"a\\b\"\n\t", time.Millisecond, 0, 0, nil)
+
+	var str strings.Builder
+	if err := metrics.WritePrometheus(&str); err != nil {
+		t.Fatalf("WritePrometheus: %s", err)
+	}
+	// Only backslashes, double quotes, and newlines are escaped
+	want := "ipc_action_errors_total{action=\"café \\\"a\\\\b\\\"\\n\t\"} 0\n"
+	expect.DeepEqual(t, strings.HasSuffix(str.String(), want), true)
+}
+
+func TestMetricsHooks(t *testing.T) {
+	stdin := make(chan string)
+	stdout := make(chan string)
+	go serveEcho(t, stdin, stdout)
+
+	metrics := NewMetrics()
+	tracer := newTracer(metrics)
+	client := NewClient(stdin, stdout)
+	client.Use(tracer.hooks())
+	if err := client.Call("echo", "foo", nil); err != nil {
+		t.Fatalf("Call: %s", err)
+	}
+	client.Call("missing", nil, nil)
+
+	expect.DeepEqual(t, metrics.actions["echo"].count, uint64(1))
+	expect.DeepEqual(t, metrics.actions["echo"].errors, uint64(0))
+	expect.DeepEqual(t, metrics.actions["missing"].errors, uint64(1))
+	expect.DeepEqual(t, len(tracer.pending), 0)
+}
+
+func TestMetricsHooksIgnore(t *testing.T) {
+	stdin := make(chan string)
+	stdout := make(chan string)
+	go serveEcho(t, stdin, stdout)
+
+	metrics := NewMetrics()
+	client := NewClient(stdin, stdout)
+	client.Use(metrics.Hooks("echo"))
+	if err := client.Call("echo", "foo", nil); err != nil {
+		t.Fatalf("Call: %s", err)
+	}
+	client.Call("missing", nil, nil)
+	expect.DeepEqual(t, metrics.sortedActions(), []string{"missing"})
+}
+
+func TestMetricsHooksAbandoned(t *testing.T) {
+	stdin := make(chan string)
+	stdout := make(chan string)
+	go func() {
+		// Never respond
+		for range stdin {
+		}
+	}()
+
+	metrics := NewMetrics()
+	tracer := newTracer(metrics)
+	client := NewClient(stdin, stdout)
+	client.Use(tracer.hooks())
+	pending := func() int {
+		tracer.mu.Lock()
+		defer tracer.mu.Unlock()
+		return len(tracer.pending)
+	}
+
+	// Cancelled calls are forgotten
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	expect.DeepEqual(t, client.CallContext(ctx, "ping", nil, nil), context.DeadlineExceeded)
+	expect.DeepEqual(t, pending(), 0)
+
+	// Calls pending when the client closes are forgotten
+	done := make(chan error)
+	go func() { done <- client.Call("ping", nil, nil) }()
+	for pending() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	close(stdout)
+	expect.DeepEqual(t, <-done, ErrClosed)
+	expect.DeepEqual(t, pending(), 0)
+	expect.DeepEqual(t, len(metrics.actions), 0)
+}
@@ -0,0 +1,171 @@
+package ipc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Calls a JSON-RPC method and decodes the result into result, which can be
+// nil. Implemented by *Client and *Pool.
+type Caller interface {
+	CallContext(ctx context.Context, method string, params, result interface{}) error
+}
+
+// Adapts a function to a Caller, like http.HandlerFunc
+type CallerFunc func(ctx context.Context, method string, params, result interface{}) error
+
+func (f CallerFunc) CallContext(ctx context.Context, method string, params, result interface{}) error {
+	return f(ctx, method, params, result)
+}
+
+// Wraps a Caller with cross-cutting behavior, e.g. logging or retries. Only
+// JSON-RPC calls made through the Caller are covered: notifications, batches,
+// and plaintext messages sent on a transport's stdin bypass middleware.
+type Middleware func(next Caller) Caller
+
+// Wraps caller with middleware. The first middleware is the outermost, e.g.
+// Chain(client, Logging(...), Retry(...)) logs once per call, not per attempt.
+func Chain(caller Caller, middleware ...Middleware) Caller {
+	for index := len(middleware) - 1; index >= 0; index-- {
+		caller = middleware[index](caller)
+	}
+	return caller
+}
+
+// Logs each JSON-RPC call's method, params, duration, and error with logf,
+// e.g. log.Printf. Values of object keys in redact, e.g. "password", are
+// replaced with "[REDACTED]" at any depth; keys are matched case-insensitively.
+func Logging(logf func(format string, args ...interface{}), redact ...string) Middleware {
+	return func(next Caller) Caller {
+		return CallerFunc(func(ctx context.Context, method string, params, result interface{}) error {
+			start := time.Now()
+			err := next.CallContext(ctx, method, params, result)
+			elapsed := time.Since(start).Round(time.Millisecond)
+			if err != nil {
+				logf("ipc: %s %s failed after %s: %s", method, Redact(params, redact...), elapsed, err)
+			} else {
+				logf("ipc: %s %s took %s", method, Redact(params, redact...), elapsed)
+			}
+			return err
+		})
+	}
+}
+
+// Encodes v as JSON with the values of object keys in keys replaced with
+// "[REDACTED]" at any depth. Keys are matched case-insensitively.
+func Redact(v interface{}, keys ...string) string {
+	byteStr, err := json.Marshal(v)
+	if err != nil {
+		return "<unencodable>"
+	}
+	if len(keys) == 0 {
+		return string(byteStr)
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(byteStr, &decoded); err != nil {
+		return "<unencodable>"
+	}
+	byteStr, _ = json.Marshal(redactValue(decoded, keys))
+	return string(byteStr)
+}
+
+func redactValue(v interface{}, keys []string) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			redacted := false
+			for _, redactKey := range keys {
+				if strings.EqualFold(key, redactKey) {
+					redacted = true
+					break
+				}
+			}
+			if redacted {
+				v[key] = "[REDACTED]"
+			} else {
+				v[key] = redactValue(value, keys)
+			}
+		}
+	case []interface{}:
+		for index, value := range v {
+			v[index] = redactValue(value, keys)
+		}
+	}
+	return v
+}
+
+// Gives each call, or each attempt when inside Retry, at most timeout
+func Timeout(timeout time.Duration) Middleware {
+	return func(next Caller) Caller {
+		return CallerFunc(func(ctx context.Context, method string, params, result interface{}) error {
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			return next.CallContext(ctx, method, params, result)
+		})
+	}
+}
+
+// Reports whether err is worth retrying: the process exited before
+// responding, e.g. a pool worker that's restarted
+func IsTransient(err error) bool {
+	return errors.Is(err, ErrClosed)
+}
+
+// Reports whether err is an attempt that timed out, e.g. under Timeout. The
+// process may still act on a timed-out call, so only retry timeouts for
+// methods that are safe to repeat.
+func IsTimeout(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
+// Retries JSON-RPC calls that fail with a transient error up to attempts
+// times in total, waiting backoff before the first retry and doubling it after
+// each retry. retryable defaults to IsTransient; to also retry timeouts, pass
+// a function that checks IsTimeout too. Retries stop when the call's context
+// is done.
+func Retry(attempts int, backoff time.Duration, retryable func(error) bool) Middleware {
+	if retryable == nil {
+		retryable = IsTransient
+	}
+	return func(next Caller) Caller {
+		return CallerFunc(func(ctx context.Context, method string, params, result interface{}) error {
+			delay := backoff
+			var err error
+			for attempt := 1; ; attempt++ {
+				err = next.CallContext(ctx, method, params, result)
+				if err == nil || attempt >= attempts || !retryable(err) || ctx.Err() != nil {
+					return err
+				}
+				timer := time.NewTimer(delay)
+				select {
+				case <-timer.C:
+				case <-ctx.Done():
+					timer.Stop()
+					return err
+				}
+				delay *= 2
+			}
+		})
+	}
+}
+
+// Validates params before they're sent. Calls with invalid params fail with
+// an *Error with InvalidParams and are never sent.
+func Validate(validate func(method string, params interface{}) error) Middleware {
+	return func(next Caller) Caller {
+		return CallerFunc(func(ctx context.Context, method string, params, result interface{}) error {
+			if err := validate(method, params); err != nil {
+				return &Error{Code: InvalidParams, Message: err.Error()}
+			}
+			return next.CallContext(ctx, method, params, result)
+		})
+	}
+}
+
+var (
+	_ Caller = (*Client)(nil)
+	_ Caller = (*Pool)(nil)
+)
@@ -0,0 +1,104 @@
+package ipc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zaydek/go-ipc-test/go/pkg/expect"
+)
+
+func TestChain(t *testing.T) {
+	var calls []string
+	trace := func(name string) Middleware {
+		return func(next Caller) Caller {
+			return CallerFunc(func(ctx context.Context, method string, params, result interface{}) error {
+				calls = append(calls, name)
+				return next.CallContext(ctx, method, params, result)
+			})
+		}
+	}
+	caller := Chain(CallerFunc(func(ctx context.Context, method string, params, result interface{}) error {
+		calls = append(calls, method)
+		return nil
+	}), trace("outer"), trace("inner"))
+
+	if err := caller.CallContext(context.Background(), "build", nil, nil); err != nil {
+		t.Fatalf("CallContext: %s", err)
+	}
+	expect.DeepEqual(t, calls, []string{"outer", "inner", "build"})
+}
+
+func TestLoggingRedacts(t *testing.T) {
+	var lines []string
+	logf := func(format string, args ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}
+	caller := Chain(CallerFunc(func(ctx context.Context, method string, params, result interface{}) error {
+		return errors.New("failed")
+	}), Logging(logf, "token"))
+
+	params := map[string]interface{}{"user": "foo", "auth": map[string]string{"Token": "secret"}}
+	caller.CallContext(context.Background(), "login", params, nil)
+	expect.DeepEqual(t, len(lines), 1)
+	// The elapsed time varies
+	expect.DeepEqual(t, strings.HasPrefix(lines[0], `ipc: login {"auth":{"Token":"[REDACTED]"},"user":"foo"} failed after `), true)
+	expect.DeepEqual(t, strings.HasSuffix(lines[0], ": failed"), true)
+}
+
+func TestRetry(t *testing.T) {
+	attempts := 0
+	caller := Chain(CallerFunc(func(ctx context.Context, method string, params, result interface{}) error {
+		attempts++
+		if attempts < 3 {
+			return ErrClosed
+		}
+		return nil
+	}), Retry(3, time.Millisecond, nil))
+
+	expect.DeepEqual(t, caller.CallContext(context.Background(), "build", nil, nil), nil)
+	expect.DeepEqual(t, attempts, 3)
+
+	// Permanent errors aren't retried
+	attempts = 0
+	caller = Chain(CallerFunc(func(ctx context.Context, method string, params, result interface{}) error {
+		attempts++
+		return &Error{Code: MethodNotFound}
+	}), Retry(3, time.Millisecond, nil))
+	caller.CallContext(context.Background(), "build", nil, nil)
+	expect.DeepEqual(t, attempts, 1)
+
+	// Timeouts are only retried when asked to
+	timeout := CallerFunc(func(ctx context.Context, method string, params, result interface{}) error {
+		attempts++
+		return context.DeadlineExceeded
+	})
+	attempts = 0
+	Chain(timeout, Retry(3, time.Millisecond, nil)).CallContext(context.Background(), "build", nil, nil)
+	expect.DeepEqual(t, attempts, 1)
+	attempts = 0
+	Chain(timeout, Retry(3, time.Millisecond, func(err error) bool {
+		return IsTransient(err) || IsTimeout(err)
+	})).CallContext(context.Background(), "build", nil, nil)
+	expect.DeepEqual(t, attempts, 3)
+}
+
+func TestValidate(t *testing.T) {
+	called := false
+	caller := Chain(CallerFunc(func(ctx context.Context, method string, params, result interface{}) error {
+		called = true
+		return nil
+	}), Validate(func(method string, params interface{}) error {
+		if params == nil {
+			return errors.New("expected params")
+		}
+		return nil
+	}))
+
+	err := caller.CallContext(context.Background(), "hashFile", nil, nil)
+	expect.DeepEqual(t, err, error(&Error{Code: InvalidParams, Message: "expected params"}))
+	expect.DeepEqual(t, called, false)
+}
@@ -0,0 +1,337 @@
+package ipc
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// Identifies one of a process's streams
+type Stream string
+
+const (
+	StreamStdin  Stream = "stdin"
+	StreamStdout Stream = "stdout"
+	StreamStderr Stream = "stderr"
+)
+
+// What to do with a message when a stream's buffer is full
+type Policy int
+
+const (
+	// Wait for the reader; the default. Blocked stdout readers eventually block
+	// the child process on a full pipe.
+	PolicyBlock Policy = iota
+
+	// Drop the oldest buffered message to make room
+	PolicyDropOldest
+
+	// Fail the stream: it's closed, Err returns *BufferFullError, and later
+	// messages are dropped
+	PolicyError
+)
+
+// Describes a stream that was failed by PolicyError
+type BufferFullError struct {
+	Stream Stream
+	Size   int
+}
+
+func (e *BufferFullError) Error() string {
+	return fmt.Sprintf("ipc: %s buffer full (%d messages)", e.Stream, e.Size)
+}
+
+type buffer struct {
+	size   int
+	policy Policy
+}
+
+type config struct {
+	buffers        map[Stream]buffer
+	maxMessageSize int
+
+	dir        string
+	env        []string
+	extraFiles []*os.File
+	stdinBytes []byte
+
+	limits          Limits
+	maxOldSpaceSize int
+	pty             bool
+	events          bool
+	eventDecoder    func(line []byte) (interface{}, bool)
+}
+
+// The default maximum size of stdout messages, in bytes
+const defaultMaxMessageSize = 1024 * 1024
+
+// Configures a process started with StartWithOptions
+type Option func(*config)
+
+// Buffers up to size messages on stream and applies policy when the buffer is
+// full. Streams are unbuffered by default. Buffers for PolicyDropOldest and
+// PolicyError hold at least one message.
+func WithBuffer(stream Stream, size int, policy Policy) Option {
+	return func(c *config) {
+		if policy != PolicyBlock && size < 1 {
+			size = 1
+		}
+		c.buffers[stream] = buffer{size: size, policy: policy}
+	}
+}
+
+// Fails stdout with *ReadError when a message exceeds size bytes. Zero means no
+// limit; the default is 1 MiB.
+func WithMaxMessageSize(size int) Option {
+	return func(c *config) {
+		c.maxMessageSize = size
+	}
+}
+
+// Runs the process in dir rather than the current directory
+func WithDir(dir string) Option {
+	return func(c *config) {
+		c.dir = dir
+	}
+}
+
+// Adds `KEY=value` variables to the environment the process inherits. Later
+// variables take precedence, including over inherited variables.
+func WithEnv(env ...string) Option {
+	return func(c *config) {
+		c.env = append(c.env, env...)
+	}
+}
+
+// Passes open files to the process as file descriptors 3, 4, etc.
+func WithExtraFiles(files ...*os.File) Option {
+	return func(c *config) {
+		c.extraFiles = append(c.extraFiles, files...)
+	}
+}
+
+// Writes b to stdin before any messages, e.g. a payload the process reads on
+// startup
+func WithStdinBytes(b []byte) Option {
+	return func(c *config) {
+		c.stdinBytes = b
+	}
+}
+
+// Resource limits applied with WithLimits. Zero means no limit.
+type Limits struct {
+	AddressSpace uint64 // Bytes of virtual memory; RLIMIT_AS
+	CPUSeconds   uint64 // Seconds of CPU time; RLIMIT_CPU
+	OpenFiles    uint64 // Open file descriptors; RLIMIT_NOFILE
+}
+
+// A limit a process exceeded; see ExitError
+type Limit string
+
+const (
+	LimitCPU    Limit = "cpu"
+	LimitMemory Limit = "memory"
+)
+
+// Applies resource limits to the process on Linux; limits are ignored
+// elsewhere. Limits are applied by `sh` before it execs the command, so they
+// cover the whole process, and are inherited by processes it starts, e.g. an
+// esbuild service. Limits above the host's hard limits fail Start.
+//
+// Processes that exceed CPUSeconds are killed with SIGXCPU and reported as
+// LimitCPU. Processes that exceed AddressSpace fail to allocate memory, which
+// Node reports as a JavaScript exception rather than a signal. AddressSpace
+// counts reserved virtual memory, and V8 reserves far more than it uses: Node
+// aborts on startup under roughly 1 GiB. Prefer WithMaxOldSpaceSize to limit
+// Node's heap.
+func WithLimits(limits Limits) Option {
+	return func(c *config) {
+		c.limits = limits
+	}
+}
+
+// Adds `--max-old-space-size` to NODE_OPTIONS, in MiB. Node aborts when its heap
+// exceeds the size, which is reported as LimitMemory.
+func WithMaxOldSpaceSize(mib int) Option {
+	return func(c *config) {
+		c.maxOldSpaceSize = mib
+	}
+}
+
+// Sends the process's output and exit to Events instead of Stdout and Stderr,
+// which are closed immediately. stderr is sent in chunks as it's read rather
+// than once. Output buffers are ignored; up to 1024 events are buffered
+// instead, after which output events are dropped; see DroppedEvents.
+//
+// Only JSON-RPC lines are sent as EventMessage unless WithEventDecoder is used.
+func WithEvents() Option {
+	return func(c *config) {
+		c.events = true
+	}
+}
+
+// Sends stdout lines that decode reports ok for as EventMessage with the
+// decoded message, e.g. for protocols other than JSON-RPC. Other lines are
+// still checked for JSON-RPC messages.
+func WithEventDecoder(decode func(line []byte) (message interface{}, ok bool)) Option {
+	return func(c *config) {
+		c.eventDecoder = decode
+	}
+}
+
+// Returned by Start when WithPTY is used on platforms other than Linux
+var ErrPTYUnsupported = errors.New("ipc: pseudo-terminals are only supported on Linux")
+
+// Runs the process under a pseudo-terminal on Linux so tools that check for a
+// terminal keep their colors and progress output. stdin and stdout are
+// attached to the terminal; stderr is still a pipe. The terminal's size
+// follows the host's terminal, or is 80x24 when the host isn't attached to
+// one.
+//
+// Echo and line editing are disabled so messages are passed through as is.
+// The terminal writes stdout lines with `\r\n`, which is trimmed.
+//
+// Closing Stdin sends end-of-file, which Node reads as the end of stdin.
+// Processes blocked in a read at the time, e.g. a shell's `read`, don't see it:
+// the terminal only ends reads that start after it's closed.
+func WithPTY() Option {
+	return func(c *config) {
+		c.pty = true
+	}
+}
+
+// Applies the options that configure the command itself
+func (c config) apply(cmd *exec.Cmd) {
+	cmd.Dir = c.dir
+	env := append([]string(nil), c.env...)
+	if c.maxOldSpaceSize > 0 {
+		// Append to NODE_OPTIONS rather than replace it
+		nodeOptions := os.Getenv("NODE_OPTIONS")
+		for _, keyValue := range c.env {
+			if strings.HasPrefix(keyValue, "NODE_OPTIONS=") {
+				nodeOptions = strings.TrimPrefix(keyValue, "NODE_OPTIONS=")
+			}
+		}
+		nodeOptions = strings.TrimSpace(nodeOptions + " --max-old-space-size=" + strconv.Itoa(c.maxOldSpaceSize))
+		env = append(env, "NODE_OPTIONS="+nodeOptions)
+	}
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	cmd.ExtraFiles = c.extraFiles
+}
+
+func newConfig(options []Option) config {
+	c := config{
+		buffers:        map[Stream]buffer{},
+		maxMessageSize: defaultMaxMessageSize,
+	}
+	for _, option := range options {
+		option(&c)
+	}
+	return c
+}
+
+// Connects a stream's producer to its consumer. Unbuffered streams are
+// connected directly; buffered streams are pumped by a goroutine that applies
+// the stream's policy. Returns the producer's channel and, for buffered
+// streams, a channel that's closed once the pump has drained the queue.
+func (p *Process) connect(stream Stream, b buffer, out chan string) (chan string, <-chan struct{}) {
+	if b.size == 0 {
+		return out, nil
+	}
+	in := make(chan string)
+	drained := make(chan struct{})
+	if stream != StreamStdin {
+		p.pumping.Add(1)
+	}
+	go func() {
+		p.pump(stream, b, in, out)
+		close(drained)
+		if stream != StreamStdin {
+			p.pumping.Done()
+		}
+	}()
+	return in, drained
+}
+
+// Moves messages from in to out through a bounded queue. Closes out once in is
+// closed and the queue is drained. Exited waits for out to be drained, so
+// buffered messages are received before the process is reported as exited.
+func (p *Process) pump(stream Stream, b buffer, in <-chan string, out chan<- string) {
+	var queue []string
+	failed := false
+	for in != nil || (len(queue) > 0 && !failed) {
+		receive := in
+		if b.policy == PolicyBlock && len(queue) >= b.size {
+			receive = nil
+		}
+		var send chan<- string
+		var next string
+		if len(queue) > 0 && !failed {
+			send = out
+			next = queue[0]
+		}
+
+		select {
+		case message, ok := <-receive:
+			if !ok {
+				in = nil
+				continue
+			}
+			switch {
+			case failed:
+				p.drop(stream)
+			case len(queue) < b.size:
+				queue = append(queue, message)
+			case b.policy == PolicyDropOldest:
+				queue = append(queue[1:], message)
+				p.drop(stream)
+			case b.policy == PolicyError:
+				p.fail(&BufferFullError{Stream: stream, Size: b.size})
+				atomic.AddInt64(p.dropped[stream], int64(len(queue)+1))
+				queue = nil
+				failed = true
+				close(out)
+			}
+		case send <- next:
+			queue = queue[1:]
+		}
+	}
+	if !failed {
+		close(out)
+	}
+}
+
+func (p *Process) drop(stream Stream) {
+	atomic.AddInt64(p.dropped[stream], 1)
+}
+
+// Records the first error
+func (p *Process) fail(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err == nil {
+		p.err = err
+	}
+}
+
+// Returns the number of messages dropped from stream's buffer
+func (p *Process) Dropped(stream Stream) int64 {
+	if counter, ok := p.dropped[stream]; ok {
+		return atomic.LoadInt64(counter)
+	}
+	return 0
+}
+
+// Returns the first error from the process's streams, if any, e.g.
+// *BufferFullError or *ReadError
+func (p *Process) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
@@ -0,0 +1,152 @@
+package ipc
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zaydek/go-ipc-test/go/pkg/expect"
+)
+
+func TestWithBufferDropOldest(t *testing.T) {
+	process, err := StartWithOptions([]string{"printf", `1\n2\n3\n4\n5\n`},
+		WithBuffer(StreamStdout, 2, PolicyDropOldest))
+	if err != nil {
+		t.Fatalf("StartWithOptions: %s", err)
+	}
+	// The process exits without anyone reading stdout
+	for process.Dropped(StreamStdout) < 3 {
+		time.Sleep(time.Millisecond)
+	}
+
+	var lines []string
+	for line := range process.Stdout() {
+		lines = append(lines, line)
+	}
+	expect.DeepEqual(t, lines, []string{"4", "5"})
+	expect.DeepEqual(t, process.Dropped(StreamStdout), int64(3))
+	<-process.Exited()
+	expect.DeepEqual(t, process.Err(), nil)
+}
+
+func TestWithBufferOrdering(t *testing.T) {
+	process, err := StartWithOptions([]string{"printf", `1\n2\n3\n`},
+		WithBuffer(StreamStdout, 3, PolicyBlock))
+	if err != nil {
+		t.Fatalf("StartWithOptions: %s", err)
+	}
+	// Let the process exit with its stdout buffered
+	time.Sleep(50 * time.Millisecond)
+
+	// Stderr is closed and Exited fires once buffered stdout is received
+	select {
+	case <-process.Stderr():
+		t.Fatal("process.Stderr: closed before stdout was drained")
+	case <-process.Exited():
+		t.Fatal("process.Exited: closed before stdout was drained")
+	default:
+	}
+	var lines []string
+	for line := range process.Stdout() {
+		lines = append(lines, line)
+	}
+	expect.DeepEqual(t, lines, []string{"1", "2", "3"})
+	_, ok := <-process.Stderr()
+	expect.DeepEqual(t, ok, false)
+	<-process.Exited()
+}
+
+func TestWithBufferError(t *testing.T) {
+	process, err := StartWithOptions([]string{"printf", `1\n2\n3\n4\n5\n`},
+		WithBuffer(StreamStdout, 2, PolicyError))
+	if err != nil {
+		t.Fatalf("StartWithOptions: %s", err)
+	}
+	<-process.Exited()
+
+	_, ok := <-process.Stdout()
+	expect.DeepEqual(t, ok, false)
+	expect.DeepEqual(t, process.Dropped(StreamStdout), int64(5))
+	expect.DeepEqual(t, process.Err(), error(&BufferFullError{Stream: StreamStdout, Size: 2}))
+}
+
+func TestWithMaxMessageSize(t *testing.T) {
+	process, err := StartWithOptions([]string{"printf", `ok\n01234567890123456789\nafter\n`},
+		WithMaxMessageSize(10))
+	if err != nil {
+		t.Fatalf("StartWithOptions: %s", err)
+	}
+
+	var lines []string
+	for line := range process.Stdout() {
+		lines = append(lines, line)
+	}
+	expect.DeepEqual(t, lines, []string{"ok"})
+	<-process.Exited()
+	expect.DeepEqual(t, process.Err(), error(&ReadError{Stream: StreamStdout, Size: 20, Err: ErrMessageTooLarge}))
+}
+
+func TestWithMaxMessageSizeUnlimited(t *testing.T) {
+	process, err := StartWithOptions([]string{"sh", "-c", "head -c 2000000 /dev/zero | tr '\\0' a; echo"},
+		WithMaxMessageSize(0))
+	if err != nil {
+		t.Fatalf("StartWithOptions: %s", err)
+	}
+
+	line := <-process.Stdout()
+	expect.DeepEqual(t, len(line), 2000000)
+	<-process.Exited()
+	expect.DeepEqual(t, process.Err(), nil)
+}
+
+func TestWithDirAndEnv(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatalf("filepath.EvalSymlinks: %s", err)
+	}
+	process, err := StartWithOptions([]string{"sh", "-c", `pwd; echo "$FOO"`},
+		WithDir(dir), WithEnv("FOO=foo", "FOO=bar"))
+	if err != nil {
+		t.Fatalf("StartWithOptions: %s", err)
+	}
+
+	var lines []string
+	for line := range process.Stdout() {
+		lines = append(lines, line)
+	}
+	expect.DeepEqual(t, lines, []string{dir, "bar"})
+}
+
+func TestWithExtraFilesAndStdinBytes(t *testing.T) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe: %s", err)
+	}
+	defer reader.Close()
+	writer.WriteString("from fd 3\n")
+	writer.Close()
+
+	process, err := StartWithOptions([]string{"sh", "-c", "head -n 1 <&3; head -n 1"},
+		WithExtraFiles(reader), WithStdinBytes([]byte("from stdin\n")))
+	if err != nil {
+		t.Fatalf("StartWithOptions: %s", err)
+	}
+
+	var lines []string
+	for line := range process.Stdout() {
+		lines = append(lines, line)
+	}
+	expect.DeepEqual(t, lines, []string{"from fd 3", "from stdin"})
+}
+
+func TestWithMaxOldSpaceSize(t *testing.T) {
+	process, err := StartWithOptions([]string{"sh", "-c", `echo "$NODE_OPTIONS"`},
+		WithEnv("NODE_OPTIONS=--enable-source-maps"), WithMaxOldSpaceSize(512))
+	if err != nil {
+		t.Fatalf("StartWithOptions: %s", err)
+	}
+	expect.DeepEqual(t, <-process.Stdout(), "--enable-source-maps --max-old-space-size=512")
+	for range process.Stdout() {
+	}
+}
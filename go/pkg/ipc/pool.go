@@ -0,0 +1,246 @@
+package ipc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// How long to wait before restarting a worker that failed to start
+const restartDelay = time.Second
+
+var ErrPoolClosed = errors.New("ipc: pool closed")
+
+// A long-lived IPC process and its JSON-RPC client
+type worker struct {
+	process *Process
+	client  *Client
+}
+
+// A pool of identical long-lived IPC processes, e.g. Node processes that render
+// routes. Calls are load-balanced across idle workers so CPU-heavy work scales
+// with cores. Dead workers are restarted until the pool is closed.
+//
+// Unencoded stdout lines and stderr text from every worker are forwarded to
+// Stdout and Stderr, which must be drained until Close.
+type Pool struct {
+	commandArgs []string
+	handlers    Handlers
+
+	idle   chan *worker
+	stdout chan string
+	stderr chan string
+	done   chan struct{}
+
+	mu      sync.Mutex
+	workers map[*worker]bool
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+// Starts size workers running commandArgs
+func NewPool(size int, commandArgs ...string) (*Pool, error) {
+	if size < 1 {
+		return nil, fmt.Errorf("ipc.NewPool: size must be at least 1; got %d", size)
+	}
+
+	p := &Pool{
+		commandArgs: commandArgs,
+		idle:        make(chan *worker, size),
+		stdout:      make(chan string),
+		stderr:      make(chan string),
+		done:        make(chan struct{}),
+		workers:     map[*worker]bool{},
+	}
+	for workerIndex := 0; workerIndex < size; workerIndex++ {
+		w, err := p.startWorker()
+		if err != nil {
+			p.Close()
+			return nil, fmt.Errorf("p.startWorker: %w", err)
+		}
+		p.idle <- w
+	}
+	return p, nil
+}
+
+// Registers a handler for requests from every worker, including restarted
+// workers
+func (p *Pool) Handle(method string, handler HandlerFunc) {
+	p.handlers.Handle(method, handler)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for w := range p.workers {
+		w.client.Handle(method, handler)
+	}
+}
+
+func (p *Pool) Stdout() <-chan string {
+	return p.stdout
+}
+
+func (p *Pool) Stderr() <-chan string {
+	return p.stderr
+}
+
+// Forwards line to out unless the pool is closed, in which case line is
+// discarded so workers can exit
+func (p *Pool) forward(out chan<- string, line string) {
+	select {
+	case out <- line:
+	case <-p.done:
+	}
+}
+
+func (p *Pool) startWorker() (*worker, error) {
+	process, err := Start(p.commandArgs...)
+	if err != nil {
+		return nil, fmt.Errorf("Start: %w", err)
+	}
+	w := &worker{process: process, client: NewClient(process.Stdin(), process.Stdout())}
+
+	p.handlers.mu.RLock()
+	for method, handler := range p.handlers.handlers {
+		w.client.Handle(method, handler)
+	}
+	p.handlers.mu.RUnlock()
+
+	p.mu.Lock()
+	if p.closed {
+		// Closed while starting
+		process.Kill()
+	} else {
+		p.workers[w] = true
+	}
+	p.mu.Unlock()
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		for line := range w.client.Stdout() {
+			p.forward(p.stdout, line)
+		}
+	}()
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		for text := range process.Stderr() {
+			p.forward(p.stderr, text)
+		}
+		process.Wait()
+		p.restart(w)
+	}()
+	return w, nil
+}
+
+// Replaces a dead worker until the pool is closed
+func (p *Pool) restart(dead *worker) {
+	p.mu.Lock()
+	delete(p.workers, dead)
+	p.mu.Unlock()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		default:
+		}
+		w, err := p.startWorker()
+		if err == nil {
+			p.release(w)
+			return
+		}
+		select {
+		case <-p.done:
+			return
+		case <-time.After(restartDelay):
+		}
+	}
+}
+
+// Waits for an idle worker. Dead workers are skipped; they're restarted
+// separately.
+func (p *Pool) acquire(ctx context.Context) (*worker, error) {
+	for {
+		select {
+		case <-p.done:
+			return nil, ErrPoolClosed
+		default:
+		}
+		select {
+		case w := <-p.idle:
+			select {
+			case <-w.process.Exited():
+				continue
+			default:
+				return w, nil
+			}
+		case <-p.done:
+			return nil, ErrPoolClosed
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (p *Pool) release(w *worker) {
+	select {
+	case <-p.done:
+	case p.idle <- w:
+	}
+}
+
+// Calls method on an idle worker and decodes the result into result, which can
+// be nil
+func (p *Pool) Call(method string, params, result interface{}) error {
+	return p.CallContext(context.Background(), method, params, result)
+}
+
+// Like Call but gives up when ctx is done, including while waiting for an idle
+// worker. Workers whose call is abandoned may still be busy with it, so they're
+// killed and restarted rather than reused.
+func (p *Pool) CallContext(ctx context.Context, method string, params, result interface{}) error {
+	w, err := p.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	err = w.client.CallContext(ctx, method, params, result)
+	switch {
+	case errors.Is(err, ErrClosed):
+		// Dead workers are restarted separately
+	case err != nil && ctx.Err() != nil:
+		w.process.Kill()
+	default:
+		p.release(w)
+	}
+	return err
+}
+
+// Kills every worker and waits for them to exit. Pending calls fail with
+// ErrClosed.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	close(p.done)
+	var workers []*worker
+	for w := range p.workers {
+		workers = append(workers, w)
+	}
+	p.mu.Unlock()
+
+	var killErr error
+	for _, w := range workers {
+		if err := w.process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) && killErr == nil {
+			killErr = fmt.Errorf("w.process.Kill: %w", err)
+		}
+	}
+	p.wg.Wait()
+	return killErr
+}
@@ -0,0 +1,107 @@
+package ipc
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zaydek/go-ipc-test/go/pkg/expect"
+)
+
+// Serves "pid", which responds with the worker's pid after a delay, "exit", and
+// "hang", which never responds
+const poolTestScript = `
+	const nodeReadline = require("readline")
+
+	const readline = nodeReadline.createInterface({ input: process.stdin })
+	readline.on("line", line => {
+		const request = JSON.parse(line)
+		if (request.method === "exit") {
+			process.exit(1)
+		} else if (request.method === "hang") {
+			return
+		}
+		setTimeout(() => {
+			console.log(JSON.stringify({ jsonrpc: "2.0", id: request.id, result: process.pid }))
+		}, 50)
+	})
+`
+
+func TestPool(t *testing.T) {
+	if err := os.WriteFile("pool_test.go.script.js", []byte(poolTestScript), 0644); err != nil {
+		t.Fatalf("os.WriteFile: %s", err)
+	}
+	defer os.Remove("pool_test.go.script.js")
+
+	pool, err := NewPool(2, "node", "pool_test.go.script.js")
+	if err != nil {
+		t.Fatalf("NewPool: %s", err)
+	}
+	defer pool.Close()
+
+	// Concurrent calls are load-balanced across both workers
+	pids := map[int]bool{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for callIndex := 0; callIndex < 4; callIndex++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var pid int
+			if err := pool.Call("pid", nil, &pid); err != nil {
+				t.Errorf("Call: %s", err)
+				return
+			}
+			mu.Lock()
+			pids[pid] = true
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	expect.DeepEqual(t, len(pids), 2)
+
+	// Dead workers are restarted
+	expect.DeepEqual(t, pool.Call("exit", nil, nil), ErrClosed)
+	for callIndex := 0; callIndex < 4; callIndex++ {
+		var pid int
+		if err := pool.Call("pid", nil, &pid); err != nil {
+			t.Fatalf("Call: %s", err)
+		}
+	}
+
+	if err := pool.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+	expect.DeepEqual(t, pool.Call("pid", nil, nil), ErrPoolClosed)
+}
+
+func TestPoolCallContext(t *testing.T) {
+	if err := os.WriteFile("pool_test.go.script.js", []byte(poolTestScript), 0644); err != nil {
+		t.Fatalf("os.WriteFile: %s", err)
+	}
+	defer os.Remove("pool_test.go.script.js")
+
+	pool, err := NewPool(1, "node", "pool_test.go.script.js")
+	if err != nil {
+		t.Fatalf("NewPool: %s", err)
+	}
+	defer pool.Close()
+
+	var pid int
+	if err := pool.Call("pid", nil, &pid); err != nil {
+		t.Fatalf("Call: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	expect.DeepEqual(t, pool.CallContext(ctx, "hang", nil, nil), context.DeadlineExceeded)
+
+	// The busy worker is replaced rather than reused
+	var restartedPid int
+	if err := pool.Call("pid", nil, &restartedPid); err != nil {
+		t.Fatalf("Call: %s", err)
+	}
+	expect.DeepEqual(t, restartedPid != pid, true)
+}
@@ -0,0 +1,105 @@
+//go:build linux
+// +build linux
+
+package ipc
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Starts the process in its own process group so its descendants, e.g. an
+// esbuild service, can be killed with it. The process is killed if the host
+// dies first.
+//
+// Pdeathsig fires when the thread that started the process exits, not the
+// host, but Go only exits threads locked with runtime.LockOSThread.
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+	cmd.SysProcAttr.Pdeathsig = syscall.SIGKILL
+}
+
+// Kills the process and every descendant in its process group. Returns
+// os.ErrProcessDone when the whole group already exited, like os.Process.Kill.
+func killProcessGroup(cmd *exec.Cmd) error {
+	err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	if err == syscall.ESRCH {
+		return os.ErrProcessDone
+	} else if err != nil {
+		return fmt.Errorf("syscall.Kill: %w", err)
+	}
+	return nil
+}
+
+// Runs cmd under a shell that applies limits and then execs the command, so
+// limits are in place before the command runs. The process keeps its pid.
+// Limits above the host's hard limits are rejected since the shell can't raise
+// them.
+func limitCommand(cmd *exec.Cmd, limits Limits) error {
+	var script []string
+	check := func(resource int, name string, value uint64) error {
+		var host unix.Rlimit
+		if err := unix.Getrlimit(resource, &host); err != nil {
+			return fmt.Errorf("unix.Getrlimit: %w", err)
+		}
+		if value > host.Max {
+			return fmt.Errorf("ipc: %s limit %d exceeds the host's hard limit %d", name, value, host.Max)
+		}
+		return nil
+	}
+	if limits.AddressSpace > 0 {
+		if err := check(unix.RLIMIT_AS, "address space", limits.AddressSpace); err != nil {
+			return err
+		}
+		// In KiB, rounded down
+		kib := limits.AddressSpace / 1024
+		if kib == 0 {
+			kib = 1
+		}
+		script = append(script, fmt.Sprintf("ulimit -v %d", kib))
+	}
+	if limits.CPUSeconds > 0 {
+		if err := check(unix.RLIMIT_CPU, "CPU", limits.CPUSeconds+1); err != nil {
+			return err
+		}
+		// Send SIGXCPU at the soft limit; SIGKILL at the hard limit is
+		// indistinguishable from Kill
+		script = append(script,
+			fmt.Sprintf("ulimit -S -t %d", limits.CPUSeconds),
+			fmt.Sprintf("ulimit -H -t %d", limits.CPUSeconds+1))
+	}
+	if limits.OpenFiles > 0 {
+		if err := check(unix.RLIMIT_NOFILE, "open files", limits.OpenFiles); err != nil {
+			return err
+		}
+		script = append(script, fmt.Sprintf("ulimit -n %d", limits.OpenFiles))
+	}
+	if len(script) == 0 {
+		return nil
+	}
+	// The command's resolved path is passed as $0
+	script = append(script, `exec "$0" "$@"`)
+	cmd.Args = append([]string{"sh", "-c", strings.Join(script, " && "), cmd.Path}, cmd.Args[1:]...)
+	cmd.Path = "/bin/sh"
+	return nil
+}
+
+// Returns the limit a process killed by sig likely exceeded, if any. Node
+// aborts when its heap is exhausted.
+func exceededLimit(sig syscall.Signal, c config) Limit {
+	switch {
+	case sig == syscall.SIGXCPU:
+		return LimitCPU
+	case (sig == syscall.SIGABRT || sig == syscall.SIGTRAP) && (c.limits.AddressSpace > 0 || c.maxOldSpaceSize > 0):
+		return LimitMemory
+	}
+	return ""
+}
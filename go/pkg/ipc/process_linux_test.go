@@ -0,0 +1,152 @@
+//go:build linux
+// +build linux
+
+package ipc
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/zaydek/go-ipc-test/go/pkg/expect"
+)
+
+// Returns whether pid is running; zombies aren't running
+func running(pid string) bool {
+	stat, err := os.ReadFile("/proc/" + pid + "/stat")
+	if err != nil {
+		return false
+	}
+	return !strings.Contains(string(stat), ") Z ")
+}
+
+func TestKillProcessGroup(t *testing.T) {
+	process, err := Start("sh", "-c", "sleep 60 >/dev/null 2>&1 & echo $!; wait")
+	if err != nil {
+		t.Fatalf("Start: %s", err)
+	}
+	child := <-process.Stdout()
+	expect.DeepEqual(t, running(child), true)
+
+	if err := process.Kill(); err != nil {
+		t.Fatalf("Kill: %s", err)
+	}
+	for range process.Stdout() {
+	}
+	for range process.Stderr() {
+	}
+	process.Wait()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for running(child) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	expect.DeepEqual(t, running(child), false)
+}
+
+func TestWithLimits(t *testing.T) {
+	// Limits are applied before the command runs
+	process, err := StartWithOptions([]string{"sh", "-c", "ulimit -n; ulimit -t"},
+		WithLimits(Limits{OpenFiles: 64, CPUSeconds: 60}))
+	if err != nil {
+		t.Fatalf("StartWithOptions: %s", err)
+	}
+	expect.DeepEqual(t, <-process.Stdout(), "64")
+	expect.DeepEqual(t, <-process.Stdout(), "60")
+	for range process.Stdout() {
+	}
+	for range process.Stderr() {
+	}
+	if err := process.Wait(); err != nil {
+		t.Fatalf("Wait: %s", err)
+	}
+}
+
+func TestWithLimitsCPUExceeded(t *testing.T) {
+	process, err := StartWithOptions([]string{"sh", "-c", "while :; do :; done"},
+		WithLimits(Limits{CPUSeconds: 1}))
+	if err != nil {
+		t.Fatalf("StartWithOptions: %s", err)
+	}
+	for range process.Stdout() {
+	}
+	for range process.Stderr() {
+	}
+
+	var exitErr *ExitError
+	if err := process.Wait(); !errors.As(err, &exitErr) {
+		t.Fatalf("Wait: got %v want *ExitError", err)
+	}
+	expect.DeepEqual(t, exitErr.Code, -1)
+	expect.DeepEqual(t, exitErr.Signal, syscall.SIGXCPU)
+	expect.DeepEqual(t, exitErr.Limit, LimitCPU)
+}
+
+func TestWithLimitsErrors(t *testing.T) {
+	_, err := StartWithOptions([]string{"missing-command"}, WithLimits(Limits{OpenFiles: 64}))
+	expect.DeepEqual(t, errors.Is(err, exec.ErrNotFound), true)
+
+	var host syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &host); err != nil {
+		t.Fatalf("syscall.Getrlimit: %s", err)
+	}
+	if host.Max < ^uint64(0) {
+		_, err = StartWithOptions([]string{"true"}, WithLimits(Limits{OpenFiles: host.Max + 1}))
+		expect.DeepEqual(t, err != nil, true)
+	}
+}
+
+func TestWithLimitsAddressSpace(t *testing.T) {
+	// Node runs under a generous limit
+	process, err := StartWithOptions([]string{"node", "-e", "console.log('ok')"},
+		WithLimits(Limits{AddressSpace: 4 << 30}))
+	if err != nil {
+		t.Fatalf("StartWithOptions: %s", err)
+	}
+	expect.DeepEqual(t, <-process.Stdout(), "ok")
+	for range process.Stdout() {
+	}
+	for range process.Stderr() {
+	}
+	if err := process.Wait(); err != nil {
+		t.Fatalf("Wait: %s", err)
+	}
+
+	// V8's reservations exceed a small limit on startup
+	process, err = StartWithOptions([]string{"node", "-e", "console.log('ok')"},
+		WithLimits(Limits{AddressSpace: 256 << 20}))
+	if err != nil {
+		t.Fatalf("StartWithOptions: %s", err)
+	}
+	for range process.Stdout() {
+	}
+	for range process.Stderr() {
+	}
+	var exitErr *ExitError
+	if err := process.Wait(); !errors.As(err, &exitErr) {
+		t.Fatalf("Wait: got %v want *ExitError", err)
+	}
+	expect.DeepEqual(t, exitErr.Limit, LimitMemory)
+}
+
+func TestWithMaxOldSpaceSizeExceeded(t *testing.T) {
+	process, err := StartWithOptions([]string{"node", "-e", "const a = []; for (;;) a.push(new Array(1e5).fill(1))"},
+		WithMaxOldSpaceSize(16))
+	if err != nil {
+		t.Fatalf("StartWithOptions: %s", err)
+	}
+	for range process.Stdout() {
+	}
+	for range process.Stderr() {
+	}
+
+	var exitErr *ExitError
+	if err := process.Wait(); !errors.As(err, &exitErr) {
+		t.Fatalf("Wait: got %v want *ExitError", err)
+	}
+	expect.DeepEqual(t, exitErr.Limit, LimitMemory)
+}
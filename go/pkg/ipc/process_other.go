@@ -0,0 +1,35 @@
+//go:build !linux
+// +build !linux
+
+package ipc
+
+import (
+	"fmt"
+	"os/exec"
+	"syscall"
+)
+
+// Process groups are only supported on Linux
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// Kills the process; descendants are not killed
+func killProcessGroup(cmd *exec.Cmd) error {
+	if err := cmd.Process.Kill(); err != nil {
+		return fmt.Errorf("cmd.Process.Kill: %w", err)
+	}
+	return nil
+}
+
+// Resource limits are only supported on Linux
+func limitCommand(cmd *exec.Cmd, limits Limits) error {
+	return nil
+}
+
+// Returns the limit a process killed by sig likely exceeded, if any. Node
+// aborts when its heap is exhausted.
+func exceededLimit(sig syscall.Signal, c config) Limit {
+	if (sig == syscall.SIGABRT || sig == syscall.SIGTRAP) && c.maxOldSpaceSize > 0 {
+		return LimitMemory
+	}
+	return ""
+}
@@ -0,0 +1,160 @@
+//go:build linux
+// +build linux
+
+package ipc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// A pseudo-terminal a process's stdin and stdout are attached to. Reads and
+// writes go through the master side.
+type pty struct {
+	master *os.File
+	slave  *os.File
+
+	stopResizing chan struct{}
+}
+
+// Opens a pseudo-terminal and attaches cmd's stdin and stdout to it. The
+// process starts a new session with the terminal as its controlling terminal,
+// which also puts it in its own process group.
+func openPTY(cmd *exec.Cmd) (*pty, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("os.OpenFile: %w", err)
+	}
+	if err := unix.IoctlSetPointerInt(int(master.Fd()), unix.TIOCSPTLCK, 0); err != nil {
+		master.Close()
+		return nil, fmt.Errorf("unix.IoctlSetPointerInt: %w", err)
+	}
+	number, err := unix.IoctlGetInt(int(master.Fd()), unix.TIOCGPTN)
+	if err != nil {
+		master.Close()
+		return nil, fmt.Errorf("unix.IoctlGetInt: %w", err)
+	}
+	slave, err := os.OpenFile("/dev/pts/"+strconv.Itoa(number), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, fmt.Errorf("os.OpenFile: %w", err)
+	}
+	p := &pty{master: master, slave: slave, stopResizing: make(chan struct{})}
+
+	// Pass messages through as is: no echo, no line editing or length limit,
+	// and no signals for control characters. Output is still processed, e.g.
+	// `\n` is written as `\r\n`.
+	termios, err := unix.IoctlGetTermios(int(slave.Fd()), unix.TCGETS)
+	if err != nil {
+		p.close()
+		return nil, fmt.Errorf("unix.IoctlGetTermios: %w", err)
+	}
+	termios.Lflag &^= unix.ECHO | unix.ICANON | unix.ISIG | unix.IEXTEN
+	termios.Iflag &^= unix.ICRNL | unix.IXON
+	termios.Cc[unix.VMIN] = 1
+	termios.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(int(slave.Fd()), unix.TCSETS, termios); err != nil {
+		p.close()
+		return nil, fmt.Errorf("unix.IoctlSetTermios: %w", err)
+	}
+	p.resize()
+
+	cmd.Stdin = slave
+	cmd.Stdout = slave
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = false // Sessions can't be created by group leaders
+	cmd.SysProcAttr.Setsid = true
+	cmd.SysProcAttr.Setctty = true
+	cmd.SysProcAttr.Ctty = 0 // stdin
+	return p, nil
+}
+
+// Copies the host's terminal size to the pseudo-terminal, which signals the
+// process with SIGWINCH. Defaults to 80x24 when the host isn't attached to a
+// terminal.
+func (p *pty) resize() {
+	size, err := unix.IoctlGetWinsize(int(os.Stdout.Fd()), unix.TIOCGWINSZ)
+	if err != nil {
+		size = &unix.Winsize{Row: 24, Col: 80}
+	}
+	unix.IoctlSetWinsize(int(p.master.Fd()), unix.TIOCSWINSZ, size)
+}
+
+// Closes the process's copy of the slave side once the process started and
+// forwards window-size changes until close is called
+func (p *pty) started() {
+	p.slave.Close()
+	resized := make(chan os.Signal, 1)
+	signal.Notify(resized, syscall.SIGWINCH)
+	go func() {
+		defer signal.Stop(resized)
+		for {
+			select {
+			case <-resized:
+				p.resize()
+			case <-p.stopResizing:
+				return
+			}
+		}
+	}()
+}
+
+// Reads the process's output. Reads fail with EIO once every process attached
+// to the terminal exits, which is reported as EOF.
+func (p *pty) Read(b []byte) (int, error) {
+	n, err := p.master.Read(b)
+	if errors.Is(err, syscall.EIO) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (p *pty) Write(b []byte) (int, error) {
+	return p.master.Write(b)
+}
+
+// Sends end-of-file to the process when stdin is closed. Closing the master
+// side would also close stdout; see close.
+//
+// VEOF is only read as end-of-file in canonical mode, so the terminal switches
+// to it; no more messages are written. Unread input before the switch doesn't
+// end in a line break as far as the terminal is concerned, so the first VEOF
+// may only flush it and the second ends the input. Reads that were already
+// blocked keep waiting for input; see WithPTY.
+func (p *pty) Close() error {
+	fd := int(p.master.Fd())
+	termios, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return fmt.Errorf("unix.IoctlGetTermios: %w", err)
+	}
+	termios.Lflag |= unix.ICANON
+	if err := unix.IoctlSetTermios(fd, unix.TCSETS, termios); err != nil {
+		return fmt.Errorf("unix.IoctlSetTermios: %w", err)
+	}
+	eof := termios.Cc[unix.VEOF]
+	if _, err := p.master.Write([]byte{eof, eof}); err != nil {
+		return fmt.Errorf("p.master.Write: %w", err)
+	}
+	return nil
+}
+
+// Closes both sides of the terminal and stops forwarding window-size changes
+func (p *pty) close() {
+	select {
+	case <-p.stopResizing:
+	default:
+		close(p.stopResizing)
+	}
+	p.slave.Close()
+	p.master.Close()
+}
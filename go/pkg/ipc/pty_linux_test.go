@@ -0,0 +1,56 @@
+//go:build linux
+// +build linux
+
+package ipc
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/zaydek/go-ipc-test/go/pkg/expect"
+)
+
+func TestWithPTY(t *testing.T) {
+	process, err := StartWithOptions([]string{"sh", "-c", `test -t 1 && echo tty; stty size; read line; echo "${#line}"; echo done >&2`},
+		WithPTY())
+	if err != nil {
+		t.Fatalf("StartWithOptions: %s", err)
+	}
+	expect.DeepEqual(t, <-process.Stdout(), "tty")
+	expect.DeepEqual(t, <-process.Stdout(), "24 80")
+
+	// Messages aren't echoed or limited to the terminal's line length
+	process.Stdin() <- strings.Repeat("a", 10000)
+	expect.DeepEqual(t, <-process.Stdout(), strconv.Itoa(10000))
+
+	_, ok := <-process.Stdout()
+	expect.DeepEqual(t, ok, false)
+	expect.DeepEqual(t, <-process.Stderr(), "done")
+	if err := process.Wait(); err != nil {
+		t.Fatalf("Wait: %s", err)
+	}
+}
+
+func TestWithPTYCloseStdin(t *testing.T) {
+	script := `require("readline").createInterface({ input: process.stdin })
+		.on("line", line => console.log(line))
+		.on("close", () => console.log("eof"))`
+	process, err := StartWithOptions([]string{"node", "-e", script}, WithPTY())
+	if err != nil {
+		t.Fatalf("StartWithOptions: %s", err)
+	}
+	process.Stdin() <- "foo"
+	expect.DeepEqual(t, <-process.Stdout(), "foo")
+
+	// Closing stdin sends end-of-file after input the process hasn't read
+	process.Stdin() <- "bar"
+	close(process.Stdin())
+	expect.DeepEqual(t, <-process.Stdout(), "bar")
+	expect.DeepEqual(t, <-process.Stdout(), "eof")
+	_, ok := <-process.Stdout()
+	expect.DeepEqual(t, ok, false)
+	if err := process.Wait(); err != nil {
+		t.Fatalf("Wait: %s", err)
+	}
+}
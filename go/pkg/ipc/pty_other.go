@@ -0,0 +1,29 @@
+//go:build !linux
+// +build !linux
+
+package ipc
+
+import "os/exec"
+
+// Pseudo-terminals are only supported on Linux
+type pty struct{}
+
+func openPTY(cmd *exec.Cmd) (*pty, error) {
+	return nil, ErrPTYUnsupported
+}
+
+func (p *pty) started() {}
+
+func (p *pty) Read(b []byte) (int, error) {
+	return 0, ErrPTYUnsupported
+}
+
+func (p *pty) Write(b []byte) (int, error) {
+	return 0, ErrPTYUnsupported
+}
+
+func (p *pty) Close() error {
+	return nil
+}
+
+func (p *pty) close() {}
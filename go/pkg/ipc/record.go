@@ -0,0 +1,378 @@
+package ipc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Directions of recorded messages
+const (
+	DirectionStdin  = "stdin"
+	DirectionStdout = "stdout"
+	DirectionStderr = "stderr"
+)
+
+// Describes a message that crossed the ipc boundary
+type Record struct {
+	Direction string
+	Time      time.Time
+	Payload   string
+}
+
+// Tees every message that crosses a transport to a JSON Lines file, e.g. to
+// attach to bug reports. See NewReplay.
+type Recorder struct {
+	transport Transport
+
+	stdin  chan string
+	stdout chan string
+	stderr chan string
+	exited chan struct{}
+
+	mu      sync.Mutex
+	encoder *json.Encoder
+	err     error
+}
+
+// Records messages crossing t to w. w is closed after t exits when w is an
+// io.Closer.
+func NewRecorder(t Transport, w io.Writer) *Recorder {
+	r := &Recorder{
+		transport: t,
+		stdin:     make(chan string),
+		stdout:    make(chan string),
+		stderr:    make(chan string),
+		exited:    make(chan struct{}),
+		encoder:   json.NewEncoder(w),
+	}
+
+	go func() {
+		for message := range r.stdin {
+			r.record(DirectionStdin, message)
+			t.Stdin() <- message
+		}
+	}()
+
+	stdoutDone := make(chan struct{})
+	go func() {
+		defer close(stdoutDone)
+		defer close(r.stdout)
+		for line := range t.Stdout() {
+			r.record(DirectionStdout, line)
+			r.stdout <- line
+		}
+	}()
+	stderrDone := make(chan struct{})
+	go func() {
+		defer close(stderrDone)
+		defer close(r.stderr)
+		// Record stderr after stdout, like Process
+		<-stdoutDone
+		for text := range t.Stderr() {
+			r.record(DirectionStderr, text)
+			r.stderr <- text
+		}
+	}()
+
+	go func() {
+		<-stderrDone
+		<-t.Exited()
+		if closer, ok := w.(io.Closer); ok {
+			r.mu.Lock()
+			if err := closer.Close(); err != nil && r.err == nil {
+				r.err = fmt.Errorf("closer.Close: %w", err)
+			}
+			r.mu.Unlock()
+		}
+		close(r.exited)
+	}()
+	return r
+}
+
+func (r *Recorder) record(direction, payload string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	if err := r.encoder.Encode(Record{Direction: direction, Time: time.Now(), Payload: payload}); err != nil {
+		r.err = fmt.Errorf("encoder.Encode: %w", err)
+	}
+}
+
+// Returns the first error writing records, if any. Recording stops after the
+// first error but messages are still forwarded.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+func (r *Recorder) Stdin() chan<- string {
+	return r.stdin
+}
+
+func (r *Recorder) Stdout() <-chan string {
+	return r.stdout
+}
+
+func (r *Recorder) Stderr() <-chan string {
+	return r.stderr
+}
+
+func (r *Recorder) Kill() error {
+	return r.transport.Kill()
+}
+
+func (r *Recorder) Exited() <-chan struct{} {
+	return r.exited
+}
+
+func (r *Recorder) Wait() error {
+	<-r.exited
+	return r.transport.Wait()
+}
+
+// Reads records written by a Recorder. Records aren't limited in size, e.g.
+// recorded esbuild metafiles can be tens of megabytes.
+func ReadRecords(r io.Reader) ([]Record, error) {
+	var records []Record
+	reader := bufio.NewReader(r)
+	for lineIndex := 1; ; lineIndex++ {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("reader.ReadBytes: %w", err)
+		}
+		if line := bytes.TrimSpace(line); len(line) > 0 {
+			var record Record
+			if err := json.Unmarshal(line, &record); err != nil {
+				return nil, fmt.Errorf("json.Unmarshal: line %d: %w", lineIndex, err)
+			}
+			records = append(records, record)
+		}
+		if err == io.EOF {
+			return records, nil
+		}
+	}
+}
+
+// Describes a stdin message that differs from the recorded session, e.g.
+// because the host changed since the session was recorded
+type ReplayMismatchError struct {
+	Want string // The recorded message
+	Got  string
+}
+
+func (e *ReplayMismatchError) Error() string {
+	return fmt.Sprintf("ipc: replay mismatch: got %q want %q", e.Got, e.Want)
+}
+
+// Replays a recorded session to the host without the recorded process. stdout
+// and stderr records are sent in order; stdin records wait for the host to send
+// the next message. Replay stops at the first message that differs from the
+// recording: stdout and stderr are closed and Err returns the
+// *ReplayMismatchError.
+//
+// JSON-RPC messages aren't deterministic so messages from the host, e.g. pings
+// and responses, are never waited for. Requests from the host are answered
+// with a null result instead of the recorded responses.
+type Replay struct {
+	records []Record
+
+	stdin  chan string
+	stdout chan string
+	stderr chan string
+	exited chan struct{}
+	killed chan struct{}
+	once   sync.Once
+
+	mu  sync.Mutex
+	err error
+}
+
+// Starts replaying records
+func NewReplay(records []Record) *Replay {
+	r := &Replay{
+		records: records,
+		stdin:   make(chan string),
+		stdout:  make(chan string),
+		stderr:  make(chan string),
+		exited:  make(chan struct{}),
+		killed:  make(chan struct{}),
+	}
+	actions := make(chan string)
+	replies := make(chan string)
+	go func() {
+		// Drains stdin so senders never block, like Process
+		for message := range r.stdin {
+			if IsMessage([]byte(message)) {
+				r.answer(message, replies)
+				continue
+			}
+			select {
+			case actions <- message:
+			case <-r.exited:
+			}
+		}
+	}()
+	go r.replay(actions, replies)
+	return r
+}
+
+// Answers requests from the host with a null result
+func (r *Replay) answer(message string, replies chan<- string) {
+	messages, err := DecodeMessages([]byte(message))
+	if err != nil {
+		return
+	}
+	var responses []interface{}
+	for _, request := range messages.Requests {
+		if !request.IsNotification() {
+			responses = append(responses, &Response{JSONRPC: jsonrpcVersion, ID: request.ID})
+		}
+	}
+	if len(responses) == 0 {
+		return
+	}
+	line, err := Encode(responses...)
+	if err != nil {
+		return
+	}
+	select {
+	case replies <- line:
+	case <-r.exited:
+	}
+}
+
+// Reports whether a recorded stdout line only has JSON-RPC responses, which
+// answered the recorded host's requests rather than the replaying host's
+func isRecordedResponse(line string) bool {
+	if !IsMessage([]byte(line)) {
+		return false
+	}
+	messages, err := DecodeMessages([]byte(line))
+	return err == nil && len(messages.Requests) == 0
+}
+
+// Sends a line to stdout, answering host requests meanwhile. Returns false when
+// killed.
+func (r *Replay) send(line string, replies <-chan string) bool {
+	for {
+		select {
+		case r.stdout <- line:
+			return true
+		case reply := <-replies:
+			select {
+			case r.stdout <- reply:
+			case <-r.killed:
+				return false
+			}
+		case <-r.killed:
+			return false
+		}
+	}
+}
+
+func (r *Replay) replay(actions, replies <-chan string) {
+	defer close(r.exited)
+
+	var stderrText string
+	defer func() {
+		close(r.stdout)
+		if stderrText != "" {
+			select {
+			case r.stderr <- stderrText:
+			case <-r.killed:
+			}
+		}
+		close(r.stderr)
+	}()
+
+	for _, record := range r.records {
+		switch record.Direction {
+		case DirectionStdin:
+			if IsMessage([]byte(record.Payload)) {
+				continue
+			}
+			for waiting := true; waiting; {
+				select {
+				case action := <-actions:
+					if action != record.Payload {
+						r.mismatch(&ReplayMismatchError{Want: record.Payload, Got: action})
+						return
+					}
+					waiting = false
+				case reply := <-replies:
+					select {
+					case r.stdout <- reply:
+					case <-r.killed:
+						return
+					}
+				case <-r.killed:
+					return
+				}
+			}
+		case DirectionStdout:
+			if isRecordedResponse(record.Payload) {
+				continue
+			}
+			if !r.send(record.Payload, replies) {
+				return
+			}
+		case DirectionStderr:
+			stderrText += record.Payload
+		}
+	}
+}
+
+func (r *Replay) mismatch(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+// Returns the first *ReplayMismatchError, if any
+func (r *Replay) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+func (r *Replay) Stdin() chan<- string {
+	return r.stdin
+}
+
+func (r *Replay) Stdout() <-chan string {
+	return r.stdout
+}
+
+func (r *Replay) Stderr() <-chan string {
+	return r.stderr
+}
+
+// Stops replaying
+func (r *Replay) Kill() error {
+	r.once.Do(func() { close(r.killed) })
+	return nil
+}
+
+func (r *Replay) Exited() <-chan struct{} {
+	return r.exited
+}
+
+func (r *Replay) Wait() error {
+	<-r.exited
+	return r.Err()
+}
+
+var (
+	_ Transport = (*Recorder)(nil)
+	_ Transport = (*Replay)(nil)
+)
@@ -0,0 +1,101 @@
+package ipc
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/zaydek/go-ipc-test/go/pkg/expect"
+)
+
+func TestRecordReplay(t *testing.T) {
+	records := []Record{
+		{Direction: DirectionStdout, Payload: `{"Kind":"hello","Data":{}}`},
+		{Direction: DirectionStdin, Payload: "build"},
+		{Direction: DirectionStdin, Payload: `{"jsonrpc":"2.0","id":1,"method":"ping"}`},
+		{Direction: DirectionStdout, Payload: `{"Kind":"build_done","Data":{}}`},
+		{Direction: DirectionStderr, Payload: "Error: boom"},
+	}
+
+	var buf bytes.Buffer
+	recorder := NewRecorder(NewReplay(records), &buf)
+	expect.DeepEqual(t, <-recorder.Stdout(), `{"Kind":"hello","Data":{}}`)
+	recorder.Stdin() <- "build"
+	expect.DeepEqual(t, <-recorder.Stdout(), `{"Kind":"build_done","Data":{}}`)
+	_, ok := <-recorder.Stdout()
+	expect.DeepEqual(t, ok, false)
+	expect.DeepEqual(t, <-recorder.Stderr(), "Error: boom")
+	if err := recorder.Wait(); err != nil {
+		t.Fatalf("Wait: %s", err)
+	}
+
+	// The replayed ping is skipped because JSON-RPC messages from the host are
+	// never waited for
+	recorded, err := ReadRecords(&buf)
+	if err != nil {
+		t.Fatalf("ReadRecords: %s", err)
+	}
+	for recordIndex := range recorded {
+		recorded[recordIndex].Time = records[0].Time
+	}
+	expect.DeepEqual(t, recorded, []Record{records[0], records[1], records[3], records[4]})
+}
+
+func TestReadRecordsLarge(t *testing.T) {
+	// Larger than bufio.Scanner's maximum token size, like a recorded metafile
+	records := []Record{
+		{Direction: DirectionStdout, Payload: `{"Kind":"build_done","Data":"` + strings.Repeat("a", 2*1024*1024) + `"}`},
+		{Direction: DirectionStdin, Payload: "rebuild"},
+	}
+	var buf bytes.Buffer
+	for _, record := range records {
+		byteStr, err := json.Marshal(record)
+		if err != nil {
+			t.Fatalf("json.Marshal: %s", err)
+		}
+		buf.Write(append(byteStr, '\n'))
+	}
+
+	recorded, err := ReadRecords(&buf)
+	if err != nil {
+		t.Fatalf("ReadRecords: %s", err)
+	}
+	expect.DeepEqual(t, recorded, records)
+}
+
+func TestReplayMismatch(t *testing.T) {
+	replay := NewReplay([]Record{
+		{Direction: DirectionStdin, Payload: "build"},
+		{Direction: DirectionStdout, Payload: `{"Kind":"build_done","Data":{}}`},
+	})
+	replay.Stdin() <- "rebuild"
+
+	// Replay stops at the mismatch
+	_, ok := <-replay.Stdout()
+	expect.DeepEqual(t, ok, false)
+	expect.DeepEqual(t, replay.Wait(), error(&ReplayMismatchError{Want: "build", Got: "rebuild"}))
+}
+
+func TestReplayAnswersRequests(t *testing.T) {
+	replay := NewReplay([]Record{
+		{Direction: DirectionStdout, Payload: `{"Kind":"hello","Data":{}}`},
+		{Direction: DirectionStdin, Payload: "build"},
+		{Direction: DirectionStdout, Payload: `{"jsonrpc":"2.0","id":1,"result":null}`},
+		{Direction: DirectionStdout, Payload: `{"Kind":"build_done","Data":{}}`},
+	})
+	expect.DeepEqual(t, <-replay.Stdout(), `{"Kind":"hello","Data":{}}`)
+
+	// Pings are answered while waiting for an action
+	replay.Stdin() <- `{"jsonrpc":"2.0","id":7,"method":"ping"}`
+	expect.DeepEqual(t, <-replay.Stdout(), `{"jsonrpc":"2.0","id":7,"result":null}`)
+
+	// Recorded responses answered the recorded host, so they're skipped
+	replay.Stdin() <- "build"
+	expect.DeepEqual(t, <-replay.Stdout(), `{"Kind":"build_done","Data":{}}`)
+	for range replay.Stdout() {
+	}
+	if err := replay.Wait(); err != nil {
+		t.Fatalf("Wait: %s", err)
+	}
+}
@@ -0,0 +1,450 @@
+package ipc
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Environment variables passed to processes started with Listener.Start
+const (
+	SocketEnv   = "IPC_SOCKET"    // The path of the host's socket
+	SocketIDEnv = "IPC_SOCKET_ID" // Identifies the process's connections
+)
+
+var ErrListenerClosed = errors.New("ipc: listener closed")
+
+// A Unix socket the host listens on in a temporary directory. Processes
+// started with Start connect to it; other connections, e.g. from an editor
+// extension, are returned by Accept.
+//
+// Every connection must send one line identifying itself first. Processes send
+// the value of IPC_SOCKET_ID so they can reconnect after the connection drops;
+// other connections can send any other line, e.g. an empty one. IDs are random
+// so other local clients can't take over a process's connection by guessing
+// its ID.
+type Listener struct {
+	dir            string
+	listener       net.Listener
+	maxMessageSize int
+
+	conns  chan *Conn
+	closed chan struct{}
+	once   sync.Once
+
+	mu        sync.Mutex
+	processes map[string]*SocketProcess
+}
+
+// Listens on a Unix socket in a new temporary directory. Only
+// WithMaxMessageSize applies to connections returned by Accept; processes
+// started with Start take their own options.
+func Listen(options ...Option) (*Listener, error) {
+	dir, err := os.MkdirTemp("", "ipc")
+	if err != nil {
+		return nil, fmt.Errorf("os.MkdirTemp: %w", err)
+	}
+	listener, err := net.Listen("unix", filepath.Join(dir, "ipc.sock"))
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("net.Listen: %w", err)
+	}
+	l := &Listener{
+		dir:            dir,
+		listener:       listener,
+		maxMessageSize: newConfig(options).maxMessageSize,
+		conns:          make(chan *Conn),
+		closed:         make(chan struct{}),
+		processes:      map[string]*SocketProcess{},
+	}
+	go l.acceptLoop()
+	return l, nil
+}
+
+// The path of the socket
+func (l *Listener) Path() string {
+	return l.listener.Addr().String()
+}
+
+func (l *Listener) acceptLoop() {
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			return
+		}
+		go l.route(conn)
+	}
+}
+
+// Routes a connection by its identifying line
+func (l *Listener) route(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	id, err := reader.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return
+	}
+	id = strings.TrimSpace(id)
+
+	if process := l.lookup(id); process != nil {
+		process.attach(conn, reader)
+		return
+	}
+
+	select {
+	case l.conns <- newConn(conn, reader, l.maxMessageSize):
+	case <-l.closed:
+		conn.Close()
+	}
+}
+
+// Returns the process identified by id, if any. IDs are compared in constant
+// time so timing doesn't leak them.
+func (l *Listener) lookup(id string) *SocketProcess {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var found *SocketProcess
+	for processID, process := range l.processes {
+		if subtle.ConstantTimeCompare([]byte(processID), []byte(id)) == 1 {
+			found = process
+		}
+	}
+	return found
+}
+
+// Returns a random, unguessable process ID
+func newSocketID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("rand.Read: %w", err)
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// Waits for a connection that isn't from a process started with Start
+func (l *Listener) Accept() (*Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, ErrListenerClosed
+	}
+}
+
+// Stops listening and removes the socket. Processes and connections are
+// unaffected.
+func (l *Listener) Close() error {
+	var err error
+	l.once.Do(func() {
+		close(l.closed)
+		if closeErr := l.listener.Close(); closeErr != nil {
+			err = fmt.Errorf("l.listener.Close: %w", closeErr)
+		}
+		if removeErr := os.RemoveAll(l.dir); removeErr != nil && err == nil {
+			err = fmt.Errorf("os.RemoveAll: %w", removeErr)
+		}
+	})
+	return err
+}
+
+// Writes lines to conn, ignoring errors after conn is closed
+func writeLines(conn net.Conn, lines <-chan string) {
+	for line := range lines {
+		fmt.Fprintln(conn, line)
+	}
+}
+
+// Sends lines read from reader to out until EOF or an error, e.g. a message
+// over maxSize bytes
+func readLines(reader *bufio.Reader, maxSize int, out chan<- string) error {
+	return readMessages(reader, StreamStdout, maxSize, func(line string) {
+		out <- line
+	})
+}
+
+// A connection to the host's socket. Connections have no stderr; Stderr is
+// closed after Stdout. Read errors, e.g. an oversized message, close the
+// connection; see Err.
+type Conn struct {
+	conn   net.Conn
+	stdin  chan string
+	stdout chan string
+	stderr chan string
+	exited chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+func newConn(conn net.Conn, reader *bufio.Reader, maxMessageSize int) *Conn {
+	c := &Conn{
+		conn:   conn,
+		stdin:  make(chan string),
+		stdout: make(chan string),
+		stderr: make(chan string),
+		exited: make(chan struct{}),
+	}
+	go writeLines(conn, c.stdin)
+	go func() {
+		if err := readLines(reader, maxMessageSize, c.stdout); err != nil && !errors.Is(err, net.ErrClosed) {
+			c.mu.Lock()
+			c.err = err
+			c.mu.Unlock()
+			conn.Close()
+		}
+		close(c.stdout)
+		close(c.stderr)
+		close(c.exited)
+	}()
+	return c
+}
+
+func (c *Conn) Stdin() chan<- string {
+	return c.stdin
+}
+
+func (c *Conn) Stdout() <-chan string {
+	return c.stdout
+}
+
+func (c *Conn) Stderr() <-chan string {
+	return c.stderr
+}
+
+// Closes the connection
+func (c *Conn) Kill() error {
+	if err := c.conn.Close(); err != nil {
+		return fmt.Errorf("c.conn.Close: %w", err)
+	}
+	return nil
+}
+
+func (c *Conn) Exited() <-chan struct{} {
+	return c.exited
+}
+
+func (c *Conn) Wait() error {
+	<-c.exited
+	return nil
+}
+
+// Returns the error that closed the connection, if any, e.g. *ReadError.
+// Connections closed by either side return nil.
+func (c *Conn) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// A long-lived IPC process that exchanges messages over the host's socket
+// rather than stdin and stdout. The process's stdout lines, e.g. logs, are
+// still forwarded to Stdout and its stderr to Stderr. stdin messages that can't
+// be written because the connection dropped are held until the process
+// reconnects. Oversized messages on the connection kill the process; see Err.
+type SocketProcess struct {
+	listener       *Listener
+	id             string
+	process        *Process
+	maxMessageSize int
+
+	stdin  chan string
+	stdout chan string
+	stderr chan string
+	exited chan struct{}
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	conn    net.Conn
+	exiting bool
+	readers sync.WaitGroup
+	err     error
+}
+
+// Starts a process with IPC_SOCKET and IPC_SOCKET_ID set
+func (l *Listener) Start(commandArgs []string, options ...Option) (*SocketProcess, error) {
+	id, err := newSocketID()
+	if err != nil {
+		return nil, fmt.Errorf("newSocketID: %w", err)
+	}
+	l.mu.Lock()
+	p := &SocketProcess{
+		listener:       l,
+		id:             id,
+		maxMessageSize: newConfig(options).maxMessageSize,
+		stdin:          make(chan string),
+		stdout:         make(chan string),
+		stderr:         make(chan string),
+		exited:         make(chan struct{}),
+	}
+	p.cond = sync.NewCond(&p.mu)
+	l.processes[id] = p
+	l.mu.Unlock()
+
+	options = append(options, WithEnv(SocketEnv+"="+l.Path(), SocketIDEnv+"="+id))
+	process, err := StartWithOptions(commandArgs, options...)
+	if err != nil {
+		l.mu.Lock()
+		delete(l.processes, id)
+		l.mu.Unlock()
+		return nil, fmt.Errorf("StartWithOptions: %w", err)
+	}
+	p.process = process
+
+	p.readers.Add(1)
+	go func() {
+		defer p.readers.Done()
+		for line := range process.Stdout() {
+			p.stdout <- line
+		}
+		p.exit()
+	}()
+	go p.writeLoop()
+	go func() {
+		// Send stderr after Stdout is closed, like Process
+		var stderrText string
+		for text := range process.Stderr() {
+			stderrText += text
+		}
+		p.readers.Wait()
+		close(p.stdout)
+		if stderrText != "" {
+			p.stderr <- stderrText
+		}
+		close(p.stderr)
+		process.Wait()
+
+		l.mu.Lock()
+		delete(l.processes, id)
+		l.mu.Unlock()
+		close(p.exited)
+	}()
+	return p, nil
+}
+
+// Replaces the process's connection, e.g. after it reconnects
+func (p *SocketProcess) attach(conn net.Conn, reader *bufio.Reader) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.exiting {
+		conn.Close()
+		return
+	}
+	if p.conn != nil {
+		p.conn.Close()
+	}
+	p.conn = conn
+	p.readers.Add(1)
+	go func() {
+		defer p.readers.Done()
+		err := readLines(reader, p.maxMessageSize, p.stdout)
+		conn.Close()
+		p.mu.Lock()
+		if p.conn == conn {
+			p.conn = nil
+		}
+		// Other read errors drop the connection like EOF; the process can
+		// reconnect
+		tooLarge := errors.Is(err, ErrMessageTooLarge)
+		if tooLarge && p.err == nil {
+			p.err = err
+		}
+		p.mu.Unlock()
+		if tooLarge {
+			p.process.Kill()
+		}
+	}()
+	p.cond.Broadcast()
+}
+
+// Closes the connection once the process exits
+func (p *SocketProcess) exit() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.exiting = true
+	if p.conn != nil {
+		p.conn.Close()
+	}
+	p.cond.Broadcast()
+}
+
+// Writes stdin messages to the current connection, waiting for the process to
+// connect or reconnect. Messages are discarded after the process exits so
+// senders never block.
+func (p *SocketProcess) writeLoop() {
+	for message := range p.stdin {
+		for {
+			p.mu.Lock()
+			for p.conn == nil && !p.exiting {
+				p.cond.Wait()
+			}
+			conn, exiting := p.conn, p.exiting
+			p.mu.Unlock()
+			if exiting {
+				break
+			}
+			if _, err := fmt.Fprintln(conn, message); err == nil {
+				break
+			}
+			// The connection dropped; wait for the process to reconnect
+			p.mu.Lock()
+			if p.conn == conn {
+				p.conn = nil
+			}
+			p.mu.Unlock()
+		}
+	}
+}
+
+func (p *SocketProcess) Pid() int {
+	return p.process.Pid()
+}
+
+func (p *SocketProcess) Stdin() chan<- string {
+	return p.stdin
+}
+
+func (p *SocketProcess) Stdout() <-chan string {
+	return p.stdout
+}
+
+func (p *SocketProcess) Stderr() <-chan string {
+	return p.stderr
+}
+
+func (p *SocketProcess) Kill() error {
+	return p.process.Kill()
+}
+
+func (p *SocketProcess) Exited() <-chan struct{} {
+	return p.exited
+}
+
+func (p *SocketProcess) Wait() error {
+	<-p.exited
+	return p.process.Wait()
+}
+
+// Returns the first error reading the connection or the process's stdout, if
+// any, e.g. *ReadError
+func (p *SocketProcess) Err() error {
+	p.mu.Lock()
+	err := p.err
+	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return p.process.Err()
+}
+
+var (
+	_ Transport = (*Conn)(nil)
+	_ Transport = (*SocketProcess)(nil)
+)
@@ -0,0 +1,176 @@
+package ipc
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/zaydek/go-ipc-test/go/pkg/expect"
+)
+
+// Echoes lines over the host's socket and reconnects after "drop"
+const socketTestScript = `
+	const net = require("net")
+	const nodeReadline = require("readline")
+
+	function connect(reconnected) {
+		const socket = net.createConnection(process.env.IPC_SOCKET)
+		socket.write(process.env.IPC_SOCKET_ID + "\n")
+		if (reconnected) {
+			socket.write("reconnected\n")
+		}
+		nodeReadline.createInterface({ input: socket }).on("line", line => {
+			if (line === "drop") {
+				socket.destroy()
+				connect(true)
+				return
+			}
+			if (line === "done") {
+				process.exit(0)
+			}
+			socket.write("echo " + line + "\n")
+		})
+	}
+
+	console.log("started")
+	connect(false)
+`
+
+func TestSocketProcess(t *testing.T) {
+	if err := os.WriteFile("socket_test.go.script.js", []byte(socketTestScript), 0644); err != nil {
+		t.Fatalf("os.WriteFile: %s", err)
+	}
+	defer os.Remove("socket_test.go.script.js")
+
+	listener, err := Listen()
+	if err != nil {
+		t.Fatalf("Listen: %s", err)
+	}
+	defer listener.Close()
+
+	process, err := listener.Start([]string{"node", "socket_test.go.script.js"})
+	if err != nil {
+		t.Fatalf("listener.Start: %s", err)
+	}
+
+	// stdout lines are forwarded alongside socket lines
+	expect.DeepEqual(t, <-process.Stdout(), "started")
+	process.Stdin() <- "foo"
+	expect.DeepEqual(t, <-process.Stdout(), "echo foo")
+
+	// Guessed IDs don't take over the process's connection
+	expect.DeepEqual(t, len(process.id), 32)
+	client, err := net.Dial("unix", listener.Path())
+	if err != nil {
+		t.Fatalf("net.Dial: %s", err)
+	}
+	defer client.Close()
+	fmt.Fprintln(client, "1")
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("listener.Accept: %s", err)
+	}
+	conn.Kill()
+	process.Stdin() <- "baz"
+	expect.DeepEqual(t, <-process.Stdout(), "echo baz")
+
+	// Reconnects replace the connection
+	process.Stdin() <- "drop"
+	expect.DeepEqual(t, <-process.Stdout(), "reconnected")
+	process.Stdin() <- "bar"
+	expect.DeepEqual(t, <-process.Stdout(), "echo bar")
+
+	process.Stdin() <- "done"
+	for range process.Stdout() {
+	}
+	for range process.Stderr() {
+	}
+	if err := process.Wait(); err != nil {
+		t.Fatalf("Wait: %s", err)
+	}
+}
+
+func TestListenerAccept(t *testing.T) {
+	listener, err := Listen()
+	if err != nil {
+		t.Fatalf("Listen: %s", err)
+	}
+	defer listener.Close()
+
+	client, err := net.Dial("unix", listener.Path())
+	if err != nil {
+		t.Fatalf("net.Dial: %s", err)
+	}
+	defer client.Close()
+	fmt.Fprintln(client, "editor")
+	fmt.Fprintln(client, "foo")
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("listener.Accept: %s", err)
+	}
+	expect.DeepEqual(t, <-conn.Stdout(), "foo")
+
+	client.Close()
+	_, ok := <-conn.Stdout()
+	expect.DeepEqual(t, ok, false)
+}
+
+func TestListenerAcceptMaxMessageSize(t *testing.T) {
+	listener, err := Listen(WithMaxMessageSize(10))
+	if err != nil {
+		t.Fatalf("Listen: %s", err)
+	}
+	defer listener.Close()
+
+	client, err := net.Dial("unix", listener.Path())
+	if err != nil {
+		t.Fatalf("net.Dial: %s", err)
+	}
+	defer client.Close()
+	fmt.Fprintln(client, "editor")
+	fmt.Fprintln(client, "ok")
+	fmt.Fprintln(client, "01234567890123456789")
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("listener.Accept: %s", err)
+	}
+	var lines []string
+	for line := range conn.Stdout() {
+		lines = append(lines, line)
+	}
+	expect.DeepEqual(t, lines, []string{"ok"})
+	expect.DeepEqual(t, conn.Err(), error(&ReadError{Stream: StreamStdout, Size: 20, Err: ErrMessageTooLarge}))
+}
+
+func TestSocketProcessMaxMessageSize(t *testing.T) {
+	if err := os.WriteFile("socket_test.go.script.js", []byte(socketTestScript), 0644); err != nil {
+		t.Fatalf("os.WriteFile: %s", err)
+	}
+	defer os.Remove("socket_test.go.script.js")
+
+	listener, err := Listen()
+	if err != nil {
+		t.Fatalf("Listen: %s", err)
+	}
+	defer listener.Close()
+
+	process, err := listener.Start([]string{"node", "socket_test.go.script.js"}, WithMaxMessageSize(10))
+	if err != nil {
+		t.Fatalf("listener.Start: %s", err)
+	}
+	expect.DeepEqual(t, <-process.Stdout(), "started")
+	process.Stdin() <- "foo"
+	expect.DeepEqual(t, <-process.Stdout(), "echo foo")
+
+	// Oversized socket messages kill the process
+	process.Stdin() <- "0123456789"
+	for range process.Stdout() {
+	}
+	for range process.Stderr() {
+	}
+	process.Wait()
+	expect.DeepEqual(t, process.Err(), error(&ReadError{Stream: StreamStdout, Size: 15, Err: ErrMessageTooLarge}))
+}
@@ -0,0 +1,170 @@
+package tsgen
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// Describes a Go struct to emit as a TypeScript interface
+type Declaration struct {
+	Type reflect.Type
+
+	// String literal types for fields, by field name, e.g. `Kind: "build_done"`
+	Literals map[string]string
+}
+
+// Emits TypeScript interfaces for Go structs.
+//
+// - Named structs that are referenced but not declared are emitted first
+// - Pointers, slices, and maps are nullable because Go encodes nil as null
+// - `json` struct tags are honored; `omitempty` fields are optional
+type Generator struct {
+	// TypeScript expressions for types declared elsewhere, e.g.
+	// `esbuild.Message` for api.Message
+	Overrides map[reflect.Type]string
+
+	dependencies []reflect.Type
+	declared     map[reflect.Type]bool
+}
+
+func (g *Generator) dependOn(t reflect.Type) {
+	if g.declared[t] {
+		return
+	}
+	for _, dependency := range g.dependencies {
+		if dependency == t {
+			return
+		}
+	}
+	g.dependencies = append(g.dependencies, t)
+}
+
+// Returns the TypeScript expression for t
+func (g *Generator) typeOf(t reflect.Type, indent string) (string, error) {
+	if override, ok := g.Overrides[t]; ok {
+		return override, nil
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "string", nil
+	case reflect.Bool:
+		return "boolean", nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number", nil
+	case reflect.Interface:
+		return "any", nil
+	case reflect.Ptr:
+		elem, err := g.typeOf(t.Elem(), indent)
+		if err != nil {
+			return "", err
+		}
+		return elem + " | null", nil
+	case reflect.Slice, reflect.Array:
+		elem, err := g.typeOf(t.Elem(), indent)
+		if err != nil {
+			return "", err
+		}
+		if strings.Contains(elem, " ") && !strings.HasPrefix(elem, "{") {
+			elem = "(" + elem + ")"
+		}
+		if t.Kind() == reflect.Array {
+			return elem + "[]", nil
+		}
+		return elem + "[] | null", nil
+	case reflect.Map:
+		key, err := g.typeOf(t.Key(), indent)
+		if err != nil {
+			return "", err
+		}
+		elem, err := g.typeOf(t.Elem(), indent)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Record<%s, %s> | null", key, elem), nil
+	case reflect.Struct:
+		if t.Name() != "" {
+			g.dependOn(t)
+			return t.Name(), nil
+		}
+		return g.fieldsOf(t, nil, indent)
+	}
+	return "", fmt.Errorf("tsgen: unsupported type %s", t)
+}
+
+// Returns the TypeScript object type for the fields of t
+func (g *Generator) fieldsOf(t reflect.Type, literals map[string]string, indent string) (string, error) {
+	var str strings.Builder
+	str.WriteString("{\n")
+	for fieldIndex := 0; fieldIndex < t.NumField(); fieldIndex++ {
+		field := t.Field(fieldIndex)
+		if field.PkgPath != "" {
+			continue // Unexported
+		}
+
+		name := field.Name
+		var optional string
+		if tag, ok := field.Tag.Lookup("json"); ok {
+			options := strings.Split(tag, ",")
+			if options[0] == "-" {
+				continue
+			} else if options[0] != "" {
+				name = options[0]
+			}
+			for _, option := range options[1:] {
+				if option == "omitempty" {
+					optional = "?"
+				}
+			}
+		}
+
+		var fieldType string
+		if literal, ok := literals[field.Name]; ok {
+			fieldType = fmt.Sprintf("%q", literal)
+		} else {
+			var err error
+			if fieldType, err = g.typeOf(field.Type, indent+"\t"); err != nil {
+				return "", err
+			}
+		}
+		fmt.Fprintf(&str, "%s\t%s%s: %s\n", indent, name, optional, fieldType)
+	}
+	str.WriteString(indent + "}")
+	return str.String(), nil
+}
+
+// Writes declarations as exported TypeScript interfaces, preceded by the named
+// structs they depend on
+func (g *Generator) Generate(w io.Writer, declarations []Declaration) error {
+	g.dependencies = nil
+	g.declared = map[reflect.Type]bool{}
+	for _, declaration := range declarations {
+		g.declared[declaration.Type] = true
+	}
+
+	var interfaces []string
+	for _, declaration := range declarations {
+		fields, err := g.fieldsOf(declaration.Type, declaration.Literals, "")
+		if err != nil {
+			return err
+		}
+		interfaces = append(interfaces, fmt.Sprintf("export interface %s %s\n", declaration.Type.Name(), fields))
+	}
+
+	// Dependencies can depend on further dependencies
+	var dependencies []string
+	for dependencyIndex := 0; dependencyIndex < len(g.dependencies); dependencyIndex++ {
+		dependency := g.dependencies[dependencyIndex]
+		fields, err := g.fieldsOf(dependency, nil, "")
+		if err != nil {
+			return err
+		}
+		dependencies = append(dependencies, fmt.Sprintf("export interface %s %s\n", dependency.Name(), fields))
+	}
+
+	_, err := io.WriteString(w, strings.Join(append(dependencies, interfaces...), "\n"))
+	return err
+}
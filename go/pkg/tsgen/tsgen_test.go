@@ -0,0 +1,72 @@
+package tsgen
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zaydek/go-ipc-test/go/pkg/expect"
+)
+
+type Location struct {
+	File string `json:"file"`
+	Line int    `json:"line"`
+}
+
+type Diagnostic struct {
+	Kind     string
+	Text     string    `json:"text"`
+	Location *Location `json:"location,omitempty"`
+	Tags     []string
+	Range    [2]int
+	Meta     map[string]interface{}
+	Hidden   string `json:"-"`
+	Data     struct {
+		Done bool
+	}
+	unexported string
+}
+
+func TestGenerate(t *testing.T) {
+	var buf bytes.Buffer
+	generator := &Generator{}
+	err := generator.Generate(&buf, []Declaration{
+		{Type: reflect.TypeOf(Diagnostic{}), Literals: map[string]string{"Kind": "diagnostic"}},
+	})
+	if err != nil {
+		t.Fatalf("Generate: %s", err)
+	}
+	expect.DeepEqual(t, buf.String(), `export interface Location {
+	file: string
+	line: number
+}
+
+export interface Diagnostic {
+	Kind: "diagnostic"
+	text: string
+	location?: Location | null
+	Tags: string[] | null
+	Range: number[]
+	Meta: Record<string, any> | null
+	Data: {
+		Done: boolean
+	}
+}
+`)
+}
+
+func TestGenerateOverrides(t *testing.T) {
+	type Event struct {
+		Time time.Time
+	}
+
+	var buf bytes.Buffer
+	generator := &Generator{
+		Overrides: map[reflect.Type]string{reflect.TypeOf(time.Time{}): "string"},
+	}
+	if err := generator.Generate(&buf, []Declaration{{Type: reflect.TypeOf(Event{})}}); err != nil {
+		t.Fatalf("Generate: %s", err)
+	}
+	expect.DeepEqual(t, buf.String(), "export interface Event {\n\tTime: string\n}\n")
+}
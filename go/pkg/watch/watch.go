@@ -0,0 +1,76 @@
+package watch
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Describes the modification times of every file under the watched paths
+type snapshot map[string]time.Time
+
+func takeSnapshot(paths []string) snapshot {
+	snap := snapshot{}
+	for _, path := range paths {
+		// Missing paths are not errors; they may be created later
+		filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			if !info.IsDir() {
+				snap[path] = info.ModTime()
+			}
+			return nil
+		})
+	}
+	return snap
+}
+
+// Returns the sorted paths that were added, removed, or modified
+func (s snapshot) diff(next snapshot) []string {
+	var changed []string
+	for path, modTime := range next {
+		if prevModTime, ok := s[path]; !ok || !prevModTime.Equal(modTime) {
+			changed = append(changed, path)
+		}
+	}
+	for path := range s {
+		if _, ok := next[path]; !ok {
+			changed = append(changed, path)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// Polls files and directories every interval and sends the paths that changed.
+// Call stop to stop polling; the changes channel is closed.
+func Watch(interval time.Duration, paths ...string) (changes <-chan []string, stop func()) {
+	ch := make(chan []string)
+	done := make(chan struct{})
+	prev := takeSnapshot(paths)
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				next := takeSnapshot(paths)
+				if changed := prev.diff(next); len(changed) > 0 {
+					select {
+					case ch <- changed:
+					case <-done:
+						return
+					}
+				}
+				prev = next
+			}
+		}
+	}()
+	return ch, func() { close(done) }
+}
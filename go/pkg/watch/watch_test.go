@@ -0,0 +1,40 @@
+package watch
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zaydek/go-ipc-test/go/pkg/expect"
+)
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.js"), []byte("a"), 0644); err != nil {
+		t.Fatalf("os.WriteFile: %s", err)
+	}
+
+	changes, stop := Watch(10*time.Millisecond, dir, filepath.Join(dir, "missing.js"))
+	defer stop()
+
+	if err := os.WriteFile(filepath.Join(dir, "b.js"), []byte("b"), 0644); err != nil {
+		t.Fatalf("os.WriteFile: %s", err)
+	}
+	select {
+	case changed := <-changes:
+		expect.DeepEqual(t, changed, []string{filepath.Join(dir, "b.js")})
+	case <-time.After(time.Second):
+		t.Fatal("Watch: timed out")
+	}
+
+	if err := os.Remove(filepath.Join(dir, "a.js")); err != nil {
+		t.Fatalf("os.Remove: %s", err)
+	}
+	select {
+	case changed := <-changes:
+		expect.DeepEqual(t, changed, []string{filepath.Join(dir, "a.js")})
+	case <-time.After(time.Second):
+		t.Fatal("Watch: timed out")
+	}
+}
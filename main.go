@@ -1,14 +1,42 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"os"
+	"strings"
 
 	"github.com/zaydek/go-ipc-test/go/cmd/retro"
 )
 
 func main() {
-	app := &retro.RetroApp{}
-	if err := app.Build(); err != nil {
+	commandMode := retro.ModeBuild
+	args := os.Args[1:]
+	if len(args) > 0 && (args[0] == retro.ModeDev || args[0] == retro.ModeBuild) {
+		commandMode = args[0]
+		args = args[1:]
+	}
+
+	flags := flag.NewFlagSet(commandMode, flag.ExitOnError)
+	vendor := flags.String("vendor", "", "comma-separated modules to bundle in vendor.js, e.g. react,react-dom")
+	dir := flags.String("dir", "", "the project directory; defaults to the current directory")
+	flags.Parse(args)
+
+	app := &retro.RetroApp{Dir: *dir}
+	if *vendor != "" {
+		app.Vendor = strings.Split(*vendor, ",")
+	}
+
+	if commandMode == retro.ModeDev {
+		if err := app.Dev(); err != nil {
+			panic(fmt.Errorf("app.Dev: %w", err))
+		}
+		return
+	}
+	if err := app.Build(); errors.Is(err, retro.ErrConfiguration) {
+		os.Exit(1)
+	} else if err != nil {
 		panic(fmt.Errorf("app.Build: %w", err))
 	}
 }
@@ -1,9 +1,12 @@
 import * as esbuild from "esbuild"
 import * as path from "path"
 import * as t from "./types"
-import readline from "./readline"
+import readline, { interceptLines } from "./readline"
+import { writeLine } from "./socket"
+import { Peer, isMessage } from "./jsonrpc"
 
 import {
+	UserConfiguration,
 	buildClientConfiguration,
 	commonConfiguration,
 	resolveUserConfiguration,
@@ -13,17 +16,46 @@ import {
 	NODE_ENV,
 	RETRO_OUT_DIR,
 	RETRO_SRC_DIR,
+	RETRO_VENDOR,
 } from "./env"
 
-function stdout(message:
-	| t.BuildVendorAndClientDoneMessage
-	| t.RebuildClientDoneMessage
-): void {
-	console.log(JSON.stringify(message))
+import {
+	resolveVendorModules,
+	vendorPlugin,
+} from "./vendor"
+
+function stdout(message: t.Message): void {
+	writeLine(JSON.stringify(message))
+}
+
+// Calls methods served by Go, e.g. `await retro.call("hashFile", "src/App.js")`
+const host = new Peer(writeLine)
+
+// Go pings periodically to detect a wedged backend. Builds are asynchronous so
+// pings are answered mid-build.
+host.handle("ping", () => "pong")
+
+declare global {
+	var retro: {
+		call: <Result = unknown>(method: string, params?: unknown) => Promise<Result>
+		notify: (method: string, params?: unknown) => void
+	}
+}
+
+// Expose host methods to plugins in `retro.config.js`
+globalThis.retro = {
+	call: (method, params) => host.call(method, params),
+	notify: (method, params) => host.notify(method, params),
 }
 
 // Describes `retro.config.js`
-let globalUserConfiguration: esbuild.BuildOptions | null = null
+let globalUserConfiguration: UserConfiguration | null = null
+
+// Describes `retro.config.js` validation errors
+let globalUserConfigurationErrors: esbuild.Message[] = []
+
+// Describes the modules bundled in the vendor bundle
+let globalVendorModules: string[] = []
 
 // Describes the bundled vendor esbuild result
 let globalVendorBuildResult: esbuild.BuildResult | null = null
@@ -32,13 +64,15 @@ let globalVendorBuildResult: esbuild.BuildResult | null = null
 let globalClientBuildResult: esbuild.BuildResult | esbuild.BuildIncremental | null = null
 
 // Builds the vendor bundle (e.g. React) and sets the global vendor variable
-async function buildVendorBundle(): Promise<t.BundleMetadata> {
-	const vendor: t.BundleMetadata = {
+async function buildVendorBundle(): Promise<t.BundleResult> {
+	const vendor: t.BundleResult = {
 		Metafile: null,
 		Warnings: [],
 		Errors: [],
+		Duration: 0,
 	}
 
+	const start = performance.now()
 	try {
 		globalVendorBuildResult = await esbuild.build({
 			...commonConfiguration,
@@ -46,9 +80,10 @@ async function buildVendorBundle(): Promise<t.BundleMetadata> {
 				? undefined
 				: "[dir]/[name]__[hash]",
 			entryPoints: {
-				"vendor": path.join(__dirname, "vendor.js"),
+				"vendor": "retro:vendor",
 			},
 			outdir: RETRO_OUT_DIR,
+			plugins: [vendorPlugin(globalVendorModules)],
 		})
 		if (globalVendorBuildResult.warnings.length > 0) { vendor.Warnings = globalVendorBuildResult.warnings }
 		if (globalVendorBuildResult.errors.length > 0) { vendor.Errors = globalVendorBuildResult.errors }
@@ -57,21 +92,24 @@ async function buildVendorBundle(): Promise<t.BundleMetadata> {
 		if (caught.warnings.length > 0) { vendor.Warnings = caught.warnings }
 		if (caught.errors.length > 0) { vendor.Errors = caught.errors }
 	}
+	vendor.Duration = performance.now() - start
 
 	return vendor
 }
 
 // Builds the client bundle (e.g. Retro) and sets the global client variable
-async function buildClientBundle(): Promise<t.BundleMetadata> {
-	const client: t.BundleMetadata = {
+async function buildClientBundle(): Promise<t.BundleResult> {
+	const client: t.BundleResult = {
 		Metafile: null,
 		Warnings: [],
 		Errors: [],
+		Duration: 0,
 	}
 
+	const start = performance.now()
 	try {
 		globalClientBuildResult = await esbuild.build({
-			...buildClientConfiguration(globalUserConfiguration),
+			...buildClientConfiguration(globalUserConfiguration, globalVendorModules),
 			entryNames: NODE_ENV !== "production"
 				? undefined
 				: "[dir]/[name]__[hash]",
@@ -82,34 +120,37 @@ async function buildClientBundle(): Promise<t.BundleMetadata> {
 		})
 		if (globalClientBuildResult.warnings.length > 0) { client.Warnings = globalClientBuildResult.warnings }
 		if (globalClientBuildResult.errors.length > 0) { client.Errors = globalClientBuildResult.errors }
-		client.Metafile = globalVendorBuildResult.metafile
+		client.Metafile = globalClientBuildResult.metafile
 	} catch (caught) {
 		if (caught.warnings.length > 0) { client.Warnings = caught.warnings }
 		if (caught.errors.length > 0) { client.Errors = caught.errors }
 	}
+	client.Duration = performance.now() - start
 
 	return client
 }
 
 // Builds the vendor and client bundles
-async function buildVendorAndClientBundles(): Promise<[t.BundleMetadata, t.BundleMetadata]> {
+async function buildVendorAndClientBundles(): Promise<[t.BundleResult, t.BundleResult]> {
 	const vendor = await buildVendorBundle()
 	const client = await buildClientBundle()
 	return [vendor, client]
 }
 
 // Builds or rebuild the client bundle
-async function rebuildClientBundle(): Promise<t.BundleMetadata> {
-	if (globalClientBuildResult === null || globalVendorBuildResult === null) {
+async function rebuildClientBundle(): Promise<t.BundleResult> {
+	if (globalClientBuildResult === null) {
 		return await buildClientBundle()
 	}
 
-	const client: t.BundleMetadata = {
+	const client: t.BundleResult = {
 		Metafile: null,
 		Warnings: [],
 		Errors: [],
+		Duration: 0,
 	}
 
+	const start = performance.now()
 	try {
 		const clientResult = await globalClientBuildResult.rebuild()
 		if (clientResult.warnings.length > 0) { client.Warnings = clientResult.warnings }
@@ -119,24 +160,84 @@ async function rebuildClientBundle(): Promise<t.BundleMetadata> {
 		if (caught.warnings.length > 0) { client.Warnings = caught.warnings }
 		if (caught.errors.length > 0) { client.Errors = caught.errors }
 	}
+	client.Duration = performance.now() - start
 
 	return client
 }
 
+// Drops the incremental build context and cached user modules (e.g.
+// `retro.config.js` and its plugins) and re-resolves the user configuration
+async function reloadUserConfiguration(): Promise<void> {
+	if (globalClientBuildResult !== null && "rebuild" in globalClientBuildResult) {
+		globalClientBuildResult.rebuild.dispose()
+	}
+	globalVendorBuildResult = null
+	globalClientBuildResult = null
+
+	for (const modulePath of Object.keys(require.cache)) {
+		if (modulePath.startsWith(process.cwd()) && !modulePath.includes("node_modules") && modulePath !== __filename) {
+			delete require.cache[modulePath]
+		}
+	}
+	;[globalUserConfiguration, globalUserConfigurationErrors] = await resolveUserConfiguration()
+	globalVendorModules = resolveVendorModules(RETRO_VENDOR, globalUserConfiguration.vendor)
+}
+
 // This becomes a Node.js IPC process, from Go to JavaScript. Messages are sent
 // as plaintext strings (actions) and received as JSON-encoded payloads.
 //
+// JSON-RPC messages are routed to the host peer as soon as they're read so
+// plugins can call Go while an action is in progress.
+//
 // stdout messages that aren't encoded should be logged regardless because
 // plugins can implement logging. stderr messages are exceptions and should
 // terminate the Node.js runtime.
 async function main(): Promise<void> {
+	interceptLines(line => {
+		if (!isMessage(line)) {
+			return false
+		}
+		host.receive(line)
+		return true
+	})
+
 	esbuild.initialize({})
-	globalUserConfiguration = await resolveUserConfiguration()
+
+	// Say hello so Go can verify `backend.esbuild.js` isn't stale
+	stdout({
+		Kind: "hello",
+		Data: {
+			ProtocolVersion: t.PROTOCOL_VERSION,
+			EsbuildVersion: esbuild.version,
+			NodeVersion: process.version,
+			Capabilities: ["reload", "vendor_cache", "jsonrpc", "heartbeat", "compression"],
+		},
+	})
+
+	;[globalUserConfiguration, globalUserConfigurationErrors] = await resolveUserConfiguration()
+	globalVendorModules = resolveVendorModules(RETRO_VENDOR, globalUserConfiguration.vendor)
 
 	while (true) {
 		const action = await readline()
+		if (action === undefined) {
+			// Go stopped without sending "done"
+			return
+		}
+		if (action === "reload") {
+			await reloadUserConfiguration()
+		}
+		if (action !== "done" && globalUserConfigurationErrors.length > 0) {
+			stdout({
+				Kind: "configuration_error",
+				Data: {
+					Errors: globalUserConfigurationErrors,
+				},
+			})
+			continue
+		}
 		switch (action) {
-			case "build": {
+			case "build":
+			case "reload": {
 				const [vendor, client] = await buildVendorAndClientBundles()
 				stdout({
 					Kind: "build_done",
@@ -147,6 +248,27 @@ async function main(): Promise<void> {
 				})
 				break
 			}
+			// Describes the inputs of the vendor bundle so Go can cache it
+			case "vendor_info":
+				stdout({
+					Kind: "vendor_info",
+					Data: {
+						Modules: globalVendorModules,
+						EsbuildVersion: esbuild.version,
+					},
+				})
+				break
+			// Builds the client bundle; the vendor bundle was restored from cache
+			case "build_client": {
+				const client = await buildClientBundle()
+				stdout({
+					Kind: "build_client_done",
+					Data: {
+						Client: client,
+					},
+				})
+				break
+			}
 			case "rebuild": {
 				const client = await rebuildClientBundle()
 				stdout({
@@ -0,0 +1,28 @@
+import zlib from "zlib"
+
+// Set by the Go host when it accepts compressed messages over
+// IPC_COMPRESSION_THRESHOLD bytes
+const IPC_COMPRESSION = process.env.IPC_COMPRESSION ?? ""
+const IPC_COMPRESSION_THRESHOLD = Number(process.env.IPC_COMPRESSION_THRESHOLD ?? "0")
+
+// Prefixes compressed messages, which are base64-encoded gzip; see
+// `go/pkg/ipc/compression.go`
+const COMPRESSED_PREFIX = "\x1egzip:"
+
+// Compresses a line when the host accepts compressed messages and the line is
+// over the threshold; otherwise returns the line
+export function compressLine(line: string): string {
+	if (IPC_COMPRESSION !== "gzip" || Buffer.byteLength(line) <= IPC_COMPRESSION_THRESHOLD) {
+		return line
+	}
+	// Favor throughput; messages are compressed once and read once
+	return COMPRESSED_PREFIX + zlib.gzipSync(line, { level: 1 }).toString("base64")
+}
+
+// Decompresses a line when it's compressed; otherwise returns the line
+export function decompressLine(line: string): string {
+	if (!line.startsWith(COMPRESSED_PREFIX)) {
+		return line
+	}
+	return zlib.gunzipSync(Buffer.from(line.slice(COMPRESSED_PREFIX.length), "base64")).toString()
+}
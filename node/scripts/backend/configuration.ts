@@ -8,16 +8,43 @@ import {
 	RETRO_WWW_DIR,
 	RETRO_SRC_DIR,
 	RETRO_OUT_DIR,
+	RETRO_PUBLIC_ENV,
 } from "./env"
 
-// Resolves `retro.config.js`
-export async function resolveUserConfiguration(): Promise<esbuild.BuildOptions> {
+import {
+	message,
+	validateUserConfiguration,
+} from "./validate"
+
+import { vendorShim } from "./vendor"
+
+// Describes `retro.config.js`; esbuild options and Retro options e.g. `vendor`
+export interface UserConfiguration extends esbuild.BuildOptions {
+	vendor?: string[]
+}
+
+// Resolves and validates `retro.config.js`. The user configuration is empty
+// when there are errors.
+export async function resolveUserConfiguration(): Promise<[UserConfiguration, esbuild.Message[]]> {
+	let source = ""
 	try {
-		await fsPromises.stat("retro.config.js")
+		source = await fsPromises.readFile("retro.config.js", "utf8")
 	} catch {
-		return {}
+		return [{}, []]
 	}
-	return require(path.join(process.cwd(), "retro.config.js"))
+
+	let userConfiguration: unknown
+	try {
+		userConfiguration = require(path.join(process.cwd(), "retro.config.js"))
+	} catch (caught) {
+		return [{}, [message(`Failed to load \`retro.config.js\`: ${caught.message}`)]]
+	}
+
+	const errors = validateUserConfiguration(userConfiguration, source)
+	if (errors.length > 0) {
+		return [{}, errors]
+	}
+	return [userConfiguration as UserConfiguration, []]
 }
 
 // The common configuration, for the vendor and client bundles
@@ -35,6 +62,12 @@ export const commonConfiguration: esbuild.BuildOptions = {
 		"process.env.RETRO_WWW_DIR": JSON.stringify(RETRO_WWW_DIR),
 		"process.env.RETRO_SRC_DIR": JSON.stringify(RETRO_SRC_DIR),
 		"process.env.RETRO_OUT_DIR": JSON.stringify(RETRO_OUT_DIR),
+
+		// User environmental variables e.g. `RETRO_PUBLIC_API_URL`
+		...Object.keys(RETRO_PUBLIC_ENV).reduce((define, key) => ({
+			...define,
+			[`process.env.${key}`]: JSON.stringify(RETRO_PUBLIC_ENV[key]),
+		}), {} as Record<string, string>),
 	},
 
 	// Load JavaScript as JavaScript React
@@ -57,31 +90,36 @@ export const commonConfiguration: esbuild.BuildOptions = {
 
 // Build the client configuration from the user configuration e.g.
 // `retro.config.js`
-export const buildClientConfiguration = (userConfiguration: esbuild.BuildOptions): esbuild.BuildOptions => ({
+export const buildClientConfiguration = (
+	{ vendor, ...userConfiguration }: UserConfiguration,
+	vendorModules: string[],
+): esbuild.BuildOptions => ({
 	...commonConfiguration,
 	...userConfiguration,
 
+	// Vendor API shims
+	banner: {
+		...userConfiguration.banner,
+		js: userConfiguration.banner?.js === undefined
+			? vendorShim(vendorModules)
+			: vendorShim(vendorModules) + "\n" + userConfiguration.banner.js,
+	},
+
 	// Global variables
 	define: {
 		...commonConfiguration.define,
 		...userConfiguration.define,
 	},
 
-	// Dedupe React APIs from `bundle.js`; React APIs are bundled in `vendor.js`
-
 	// Dedupe vendor APIs; vendor APIs are bundled in `vendor.js`
 	external: [
-		"react",
-		"react-dom",
-		"react-dom/server",
+		...(userConfiguration.external ?? []),
+		...vendorModules,
 	],
 
 	// Enable incremental compilation for development
 	incremental: NODE_ENV === "development",
 
-	// Vendor API shims
-	inject: [path.join(__dirname, "require.js")],
-
 	loader: {
 		...commonConfiguration.loader,
 		...userConfiguration.loader,
@@ -9,3 +9,12 @@ export const RETRO_CMD = process.env["RETRO_CMD"] ?? InternalError("")
 export const RETRO_WWW_DIR = process.env["RETRO_WWW_DIR"] ?? InternalError("")
 export const RETRO_SRC_DIR = process.env["RETRO_SRC_DIR"] ?? InternalError("")
 export const RETRO_OUT_DIR = process.env["RETRO_OUT_DIR"] ?? InternalError("")
+export const RETRO_VENDOR = process.env["RETRO_VENDOR"] ?? InternalError("")
+
+// Only variables prefixed with `RETRO_PUBLIC_` are exposed to the client bundle;
+// `.env` files are loaded by Go before the backend is started
+export const RETRO_PUBLIC_PREFIX = "RETRO_PUBLIC_"
+
+export const RETRO_PUBLIC_ENV = Object.keys(process.env)
+	.filter(key => key.startsWith(RETRO_PUBLIC_PREFIX))
+	.reduce((env, key) => ({ ...env, [key]: process.env[key]! }), {} as Record<string, string>)
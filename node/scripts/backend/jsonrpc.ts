@@ -0,0 +1,162 @@
+// https://www.jsonrpc.org/specification
+
+// Standard JSON-RPC 2.0 error codes
+export const PARSE_ERROR = -32700
+export const INVALID_REQUEST = -32600
+export const METHOD_NOT_FOUND = -32601
+export const INVALID_PARAMS = -32602
+export const INTERNAL_ERROR = -32603
+
+export type ID = number | string | null
+
+export interface Request {
+	jsonrpc: "2.0"
+	id?: ID
+	method: string
+	params?: unknown
+}
+
+export interface ErrorObject {
+	code: number
+	message: string
+	data?: unknown
+}
+
+export interface Response {
+	jsonrpc: "2.0"
+	id: ID
+	result?: unknown
+	error?: ErrorObject
+}
+
+export type Handler = (params: any) => unknown | Promise<unknown>
+
+// Errors thrown by handlers are sent as error objects. Throw a JSONRPCError to
+// control the code, e.g. INVALID_PARAMS.
+export class JSONRPCError extends Error {
+	code: number
+	data?: unknown
+
+	constructor(code: number, message: string, data?: unknown) {
+		super(message)
+		this.code = code
+		this.data = data
+	}
+}
+
+function isRequest(message: any): message is Request {
+	return typeof message === "object" && message !== null && message.jsonrpc === "2.0" && typeof message.method === "string"
+}
+
+function isResponse(message: any): message is Response {
+	return typeof message === "object" && message !== null && message.jsonrpc === "2.0" && "id" in message &&
+		("result" in message || "error" in message)
+}
+
+// Returns whether line looks like a JSON-RPC message. Lines that aren't
+// JSON-RPC messages are logs or plaintext actions.
+export function isMessage(line: string): boolean {
+	const trimmed = line.trim()
+	return (trimmed.startsWith("{") || trimmed.startsWith("[")) && trimmed.includes(`"jsonrpc"`)
+}
+
+// A JSON-RPC 2.0 peer over line-based IPC, e.g. stdin and stdout. Peers can
+// both serve methods and call methods on the other side.
+export class Peer {
+	private nextID = 0
+	private pending = new Map<ID, { resolve: (result: unknown) => void, reject: (error: Error) => void }>()
+
+	constructor(
+		private write: (line: string) => void,
+		private handlers: Record<string, Handler> = {},
+	) {}
+
+	handle(method: string, handler: Handler): void {
+		this.handlers[method] = handler
+	}
+
+	call<Result = unknown>(method: string, params?: unknown): Promise<Result> {
+		const id = ++this.nextID
+		return new Promise((resolve, reject) => {
+			this.pending.set(id, { resolve: resolve as (result: unknown) => void, reject })
+			this.write(JSON.stringify({ jsonrpc: "2.0", id, method, params }))
+		})
+	}
+
+	notify(method: string, params?: unknown): void {
+		this.write(JSON.stringify({ jsonrpc: "2.0", method, params }))
+	}
+
+	private async serve(request: Request): Promise<Response | null> {
+		const handler = this.handlers[request.method]
+		let response: Response
+		if (handler === undefined) {
+			response = { jsonrpc: "2.0", id: request.id ?? null, error: { code: METHOD_NOT_FOUND, message: `method not found: ${request.method}` } }
+		} else {
+			try {
+				const result = await handler(request.params)
+				response = { jsonrpc: "2.0", id: request.id ?? null, result: result ?? null }
+			} catch (caught) {
+				const code = caught instanceof JSONRPCError ? caught.code : INTERNAL_ERROR
+				const data = caught instanceof JSONRPCError ? caught.data : undefined
+				response = { jsonrpc: "2.0", id: request.id ?? null, error: { code, message: caught.message, data } }
+			}
+		}
+		// Notifications are never answered
+		return request.id === undefined ? null : response
+	}
+
+	private settle(response: Response): void {
+		const pending = this.pending.get(response.id)
+		if (pending === undefined) {
+			return
+		}
+		this.pending.delete(response.id)
+		if (response.error !== undefined) {
+			pending.reject(new JSONRPCError(response.error.code, response.error.message, response.error.data))
+		} else {
+			pending.resolve(response.result)
+		}
+	}
+
+	// Receives a line. Requests are served and responses settle pending calls.
+	// Returns false when line isn't a JSON-RPC message.
+	async receive(line: string): Promise<boolean> {
+		if (!isMessage(line)) {
+			return false
+		}
+
+		let parsed: unknown
+		try {
+			parsed = JSON.parse(line)
+		} catch (caught) {
+			this.write(JSON.stringify({ jsonrpc: "2.0", id: null, error: { code: PARSE_ERROR, message: caught.message } }))
+			return true
+		}
+
+		const batch = Array.isArray(parsed)
+		const messages = batch ? parsed as unknown[] : [parsed]
+		if (messages.length === 0) {
+			this.write(JSON.stringify({ jsonrpc: "2.0", id: null, error: { code: INVALID_REQUEST, message: "empty batch" } }))
+			return true
+		}
+
+		const responses: Response[] = []
+		for (const message of messages) {
+			if (isResponse(message)) {
+				this.settle(message)
+			} else if (isRequest(message)) {
+				const response = await this.serve(message)
+				if (response !== null) {
+					responses.push(response)
+				}
+			} else {
+				responses.push({ jsonrpc: "2.0", id: null, error: { code: INVALID_REQUEST, message: "invalid request" } })
+			}
+		}
+		if (responses.length > 0) {
+			this.write(JSON.stringify(batch ? responses : responses[0]))
+		}
+		return true
+	}
+}
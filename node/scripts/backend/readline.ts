@@ -1,15 +1,61 @@
 import nodeReadline from "readline"
+import { connect, IPC_SOCKET } from "./socket"
+import { decompressLine } from "./compression"
+
+// Lines that are intercepted are never returned by `readline`, e.g. JSON-RPC
+// responses that must be received while an action is in progress
+let intercept: ((line: string) => boolean) | null = null
+
+export function interceptLines(fn: (line: string) => boolean): void {
+	intercept = fn
+}
 
 export default (function readline(): (() => Promise<string>) {
-	async function* createReadlineGenerator(): AsyncGenerator<string> {
-		const nodeReadlineInterface = nodeReadline.createInterface({ input: process.stdin })
-		for await (const line of nodeReadlineInterface) {
-			yield line
+	const queue: string[] = []
+	const waiting: ((line: string) => void)[] = []
+	let closed = false
+
+	function onLine(line: string): void {
+		try {
+			line = decompressLine(line)
+		} catch (caught) {
+			// Drop the corrupt message rather than crash, like the host's
+			// Compressor. stdout is logged by the host, even over the socket.
+			console.log(`decompressLine: ${caught.message}`)
+			return
+		}
+		if (intercept !== null && intercept(line)) {
+			return
+		}
+		const resolve = waiting.shift()
+		if (resolve !== undefined) {
+			resolve(line)
+		} else {
+			queue.push(line)
+		}
+	}
+
+	function onClose(): void {
+		closed = true
+		for (const resolve of waiting.splice(0)) {
+			resolve(undefined as unknown as string)
 		}
 	}
-	const generate = createReadlineGenerator()
+
+	if (IPC_SOCKET !== "") {
+		connect(onLine, onClose)
+	} else {
+		const nodeReadlineInterface = nodeReadline.createInterface({ input: process.stdin })
+		nodeReadlineInterface.on("line", onLine)
+		nodeReadlineInterface.on("close", onClose)
+	}
+
 	return async () => {
-		const result = await generate.next()
-		return result.value
+		if (queue.length > 0) {
+			return queue.shift()!
+		} else if (closed) {
+			return undefined as unknown as string
+		}
+		return await new Promise<string>(resolve => waiting.push(resolve))
 	}
 })()
@@ -0,0 +1,60 @@
+import net from "net"
+import nodeReadline from "readline"
+import { compressLine } from "./compression"
+
+// Set by the Go host when messages are exchanged over its Unix socket rather
+// than stdin and stdout
+export const IPC_SOCKET = process.env.IPC_SOCKET ?? ""
+const IPC_SOCKET_ID = process.env.IPC_SOCKET_ID ?? ""
+
+const RECONNECT_DELAY = 100
+const RECONNECT_ATTEMPTS = 50
+
+let socket: net.Socket | null = null
+const pending: string[] = []
+
+// Connects to the host's socket and reconnects when the connection drops.
+// onClose is called when the host can't be reached.
+export function connect(onLine: (line: string) => void, onClose: () => void): void {
+	let attempts = 0
+	function attempt(): void {
+		const s = net.createConnection(IPC_SOCKET)
+		s.on("connect", () => {
+			attempts = 0
+			// Identify so the host can route reconnects
+			s.write(IPC_SOCKET_ID + "\n")
+			for (const line of pending.splice(0)) {
+				s.write(line + "\n")
+			}
+			socket = s
+		})
+		nodeReadline.createInterface({ input: s }).on("line", onLine)
+		s.on("error", () => {
+			// Handled by "close"
+		})
+		s.on("close", () => {
+			if (socket === s) {
+				socket = null
+			}
+			if (++attempts > RECONNECT_ATTEMPTS) {
+				onClose()
+				return
+			}
+			setTimeout(attempt, RECONNECT_DELAY)
+		})
+	}
+	attempt()
+}
+
+// Writes a line to the host, compressed when it's large. Lines written while
+// reconnecting are sent once reconnected.
+export function writeLine(line: string): void {
+	line = compressLine(line)
+	if (IPC_SOCKET === "") {
+		console.log(line)
+	} else if (socket === null) {
+		pending.push(line)
+	} else {
+		socket.write(line + "\n")
+	}
+}
@@ -0,0 +1,140 @@
+import esbuild from "esbuild"
+
+// Describes the JavaScript types accepted for each option
+type OptionType = "array" | "boolean" | "function" | "number" | "object" | "string"
+
+// esbuild options users can set in `retro.config.js`
+const userOptionTypes: Record<string, OptionType[]> = {
+	absWorkingDir: ["string"],
+	assetNames: ["string"],
+	banner: ["object"],
+	charset: ["string"],
+	chunkNames: ["string"],
+	color: ["boolean"],
+	conditions: ["array"],
+	define: ["object"],
+	external: ["array"],
+	footer: ["object"],
+	format: ["string"],
+	globalName: ["string"],
+	ignoreAnnotations: ["boolean"],
+	inject: ["array"],
+	jsxFactory: ["string"],
+	jsxFragment: ["string"],
+	keepNames: ["boolean"],
+	legalComments: ["string"],
+	loader: ["object"],
+	logLevel: ["string"],
+	logLimit: ["number"],
+	mainFields: ["array"],
+	minify: ["boolean"],
+	minifyIdentifiers: ["boolean"],
+	minifySyntax: ["boolean"],
+	minifyWhitespace: ["boolean"],
+	nodePaths: ["array"],
+	outExtension: ["object"],
+	platform: ["string"],
+	plugins: ["array"],
+	preserveSymlinks: ["boolean"],
+	publicPath: ["string"],
+	pure: ["array"],
+	resolveExtensions: ["array"],
+	sourcemap: ["boolean", "string"],
+	sourceRoot: ["string"],
+	sourcesContent: ["boolean"],
+	splitting: ["boolean"],
+	target: ["array", "string"],
+	treeShaking: ["boolean", "string"],
+	tsconfig: ["string"],
+}
+
+// Retro options users can set in `retro.config.js`
+const retroOptionTypes: Record<string, OptionType[]> = {
+	vendor: ["array"],
+}
+
+// esbuild options Retro depends on and therefore owns
+const forbiddenOptions = [
+	"allowOverwrite",
+	"bundle",
+	"entryNames",
+	"entryPoints",
+	"incremental",
+	"metafile",
+	"outbase",
+	"outdir",
+	"outfile",
+	"stdin",
+	"watch",
+	"write",
+]
+
+function typeOf(value: unknown): string {
+	if (value === null) {
+		return "null"
+	} else if (Array.isArray(value)) {
+		return "array"
+	}
+	return typeof value
+}
+
+// Finds the first `key:` in `retro.config.js` so diagnostics can point to it
+function locate(source: string, key: string): esbuild.Location | null {
+	const lines = source.split("\n")
+	for (let lineIndex = 0; lineIndex < lines.length; lineIndex++) {
+		const escapedKey = key.replace(/[.*+?^${}()|[\]\\]/g, "\\$&")
+		const match = new RegExp(`\\b${escapedKey}\\b\\s*:`).exec(lines[lineIndex])
+		if (match !== null) {
+			return {
+				file: "retro.config.js",
+				namespace: "file",
+				line: lineIndex + 1,
+				column: match.index,
+				length: key.length,
+				lineText: lines[lineIndex],
+				suggestion: "",
+			}
+		}
+	}
+	return null
+}
+
+export function message(text: string, location: esbuild.Location | null = null): esbuild.Message {
+	return {
+		pluginName: "",
+		text,
+		location,
+		notes: [],
+		detail: undefined,
+	}
+}
+
+// Validates `retro.config.js`. Unknown keys, keys Retro depends on, and values
+// of the wrong type are reported as esbuild-style errors.
+export function validateUserConfiguration(userConfiguration: unknown, source: string): esbuild.Message[] {
+	if (typeOf(userConfiguration) !== "object") {
+		return [message(`Expected \`retro.config.js\` to export an object but found ${typeOf(userConfiguration)}`)]
+	}
+
+	const errors: esbuild.Message[] = []
+	for (const [key, value] of Object.entries(userConfiguration as Record<string, unknown>)) {
+		if (forbiddenOptions.includes(key)) {
+			errors.push(message(`Option \`${key}\` is managed by Retro and cannot be overridden`, locate(source, key)))
+			continue
+		}
+		const types = userOptionTypes[key] ?? retroOptionTypes[key]
+		if (types === undefined) {
+			errors.push(message(`Unknown option \`${key}\``, locate(source, key)))
+			continue
+		}
+		if (!types.includes(typeOf(value) as OptionType)) {
+			errors.push(message(
+				`Expected option \`${key}\` to be ${types.join(" or ")} but found ${typeOf(value)}`,
+				locate(source, key),
+			))
+		} else if (key === "vendor" && !(value as unknown[]).every(moduleName => typeof moduleName === "string")) {
+			errors.push(message("Expected option `vendor` to be an array of module names", locate(source, key)))
+		}
+	}
+	return errors
+}
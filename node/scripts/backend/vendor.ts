@@ -0,0 +1,79 @@
+import esbuild from "esbuild"
+
+// The default vendor modules
+export const defaultVendorModules = [
+	"react",
+	"react-dom",
+	"react-dom/server",
+]
+
+// Vendor modules that are also exposed as global variables, e.g. `React`
+const vendorGlobals: Record<string, string> = {
+	"react": "React",
+	"react-dom": "ReactDOM",
+	"react-dom/server": "ReactDOMServer",
+}
+
+// Resolves the vendor modules. `RETRO_VENDOR` (set from the command line)
+// takes precedence over `vendor` in `retro.config.js`.
+export function resolveVendorModules(retroVendor: string, userVendor: string[] | undefined): string[] {
+	if (retroVendor !== "") {
+		return retroVendor.split(",").map(moduleName => moduleName.trim()).filter(moduleName => moduleName !== "")
+	}
+	return userVendor ?? defaultVendorModules
+}
+
+// Generates the vendor entry point. Vendor modules are registered on
+// `window.__RETRO_VENDOR__` so the client bundle can require them at runtime.
+function vendorEntryPoint(vendorModules: string[]): string {
+	let contents = `window.__RETRO_VENDOR__ = {}\n`
+	for (const moduleName of vendorModules) {
+		contents += `window.__RETRO_VENDOR__[${JSON.stringify(moduleName)}] = require(${JSON.stringify(moduleName)})\n`
+		if (moduleName in vendorGlobals) {
+			contents += `window[${JSON.stringify(vendorGlobals[moduleName])}] = window.__RETRO_VENDOR__[${JSON.stringify(moduleName)}]\n`
+		}
+	}
+	return contents
+}
+
+// Resolves the virtual `retro:vendor` entry point to the generated vendor entry
+// point
+export function vendorPlugin(vendorModules: string[]): esbuild.Plugin {
+	return {
+		name: "retro-vendor",
+		setup(build) {
+			build.onResolve({ filter: /^retro:vendor$/ }, args => ({
+				path: args.path,
+				namespace: "retro-vendor",
+			}))
+			build.onLoad({ filter: /.*/, namespace: "retro-vendor" }, () => ({
+				contents: vendorEntryPoint(vendorModules),
+				resolveDir: process.cwd(),
+				loader: "js",
+			}))
+		},
+	}
+}
+
+// Generates the vendor shim, prepended to the client bundle. Server-rendering
+// requires vendor modules from `node_modules` whereas client-side rendering
+// resolves vendor modules from the vendor bundle.
+export function vendorShim(vendorModules: string[]): string {
+	let serverGlobals = ""
+	for (const moduleName of vendorModules) {
+		if (moduleName in vendorGlobals) {
+			serverGlobals += `\t${vendorGlobals[moduleName]} = require(${JSON.stringify(moduleName)})\n`
+		}
+	}
+	return `if (typeof window === "undefined") {
+	// For server-rendering
+${serverGlobals}} else {
+	// For client-side rendering
+	window.require = function resolveVendorDepsAtRuntime(moduleName) {
+		if (!(moduleName in window.__RETRO_VENDOR__)) {
+			throw new Error("Internal error: " + moduleName + " is not a vendor module")
+		}
+		return window.__RETRO_VENDOR__[moduleName]
+	}
+}`
+}
@@ -1,7 +0,0 @@
-import React from "react"
-import ReactDOM from "react-dom"
-import ReactDOMServer from "react-dom/server"
-
-window["React"] = React
-window["ReactDOM"] = ReactDOM
-window["ReactDOMServer"] = ReactDOMServer
@@ -17,10 +17,16 @@ module.exports = {
 				})
 				// Load paths tagged with the "env-ns" namespace and behave as if
 				// they point to a JSON file containing the environment variables.
+				// Only expose `RETRO_PUBLIC_` variables so secrets don't leak into
+				// the client bundle.
 				build.onLoad({ filter: /.*/, namespace: "env-ns" }, () => {
 					console.log("b")
 					return {
-						contents: JSON.stringify(process.env),
+						contents: JSON.stringify(
+							Object.fromEntries(
+								Object.entries(process.env).filter(([key]) => key.startsWith("RETRO_PUBLIC_")),
+							),
+						),
 						loader: "json",
 					}
 				})